@@ -448,6 +448,11 @@ type RPCConfig struct {
 	// See https://github.com/tendermint/tendermint/issues/3435
 	TimeoutBroadcastTxCommit time.Duration `mapstructure:"timeout-broadcast-tx-commit"`
 
+	// How long the event bus may spend matching an event against the query of
+	// a subscription. Events whose match takes longer are not delivered to
+	// that subscription. 0 - unlimited.
+	SubscriptionMatchTimeout time.Duration `mapstructure:"subscription-match-timeout"`
+
 	// Maximum size of request body, in bytes
 	MaxBodyBytes int64 `mapstructure:"max-body-bytes"`
 
@@ -522,6 +527,9 @@ func (cfg *RPCConfig) ValidateBasic() error {
 	if cfg.TimeoutBroadcastTxCommit < 0 {
 		return errors.New("timeout-broadcast-tx-commit can't be negative")
 	}
+	if cfg.SubscriptionMatchTimeout < 0 {
+		return errors.New("subscription-match-timeout can't be negative")
+	}
 	if cfg.MaxBodyBytes < 0 {
 		return errors.New("max-body-bytes can't be negative")
 	}
@@ -935,6 +943,11 @@ type ConsensusConfig struct {
 	// though we already have +2/3).
 	TimeoutCommit time.Duration `mapstructure:"timeout-commit"`
 
+	// Shorten timeout-propose, timeout-prevote and timeout-precommit to twice a
+	// moving average of the observed durations of the steps they bound, but to no
+	// less than a quarter of the configured values.
+	AdaptiveTimeouts bool `mapstructure:"adaptive-timeouts"`
+
 	// Make progress as soon as we have all the precommits (as if TimeoutCommit = 0)
 	SkipTimeoutCommit bool `mapstructure:"skip-timeout-commit"`
 
@@ -960,6 +973,7 @@ func DefaultConsensusConfig() *ConsensusConfig {
 		TimeoutPrecommit:            1000 * time.Millisecond,
 		TimeoutPrecommitDelta:       500 * time.Millisecond,
 		TimeoutCommit:               1000 * time.Millisecond,
+		AdaptiveTimeouts:            false,
 		SkipTimeoutCommit:           false,
 		CreateEmptyBlocks:           true,
 		CreateEmptyBlocksInterval:   0 * time.Second,
@@ -1130,6 +1144,11 @@ type InstrumentationConfig struct {
 
 	// Instrumentation namespace.
 	Namespace string `mapstructure:"namespace"`
+
+	// When true, the event bus query metrics are reported separately for each
+	// query that clients subscribe with. Clients control the number of
+	// distinct queries, and their series are never removed.
+	QueryLabels bool `mapstructure:"query-labels"`
 }
 
 // DefaultInstrumentationConfig returns a default configuration for metrics
@@ -1140,6 +1159,7 @@ func DefaultInstrumentationConfig() *InstrumentationConfig {
 		PrometheusListenAddr: ":26660",
 		MaxOpenConnections:   3,
 		Namespace:            "tendermint",
+		QueryLabels:          false,
 	}
 }
 
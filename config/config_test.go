@@ -70,6 +70,7 @@ func TestRPCConfigValidateBasic(t *testing.T) {
 		"MaxSubscriptionClients",
 		"MaxSubscriptionsPerClient",
 		"TimeoutBroadcastTxCommit",
+		"SubscriptionMatchTimeout",
 		"MaxBodyBytes",
 		"MaxHeaderBytes",
 	}
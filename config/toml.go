@@ -225,6 +225,11 @@ max-subscriptions-per-client = {{ .RPC.MaxSubscriptionsPerClient }}
 # See https://github.com/tendermint/tendermint/issues/3435
 timeout-broadcast-tx-commit = "{{ .RPC.TimeoutBroadcastTxCommit }}"
 
+# How long the event bus may spend matching an event against the query of
+# a subscription. Events whose match takes longer are not delivered to
+# that subscription. 0 - unlimited.
+subscription-match-timeout = "{{ .RPC.SubscriptionMatchTimeout }}"
+
 # Maximum size of request body, in bytes
 max-body-bytes = {{ .RPC.MaxBodyBytes }}
 
@@ -439,6 +444,11 @@ timeout-precommit-delta = "{{ .Consensus.TimeoutPrecommitDelta }}"
 # though we already have +2/3).
 timeout-commit = "{{ .Consensus.TimeoutCommit }}"
 
+# Shorten timeout-propose, timeout-prevote and timeout-precommit to twice a
+# moving average of the observed durations of the steps they bound, but to no
+# less than a quarter of the configured values.
+adaptive-timeouts = {{ .Consensus.AdaptiveTimeouts }}
+
 # How many blocks to look back to check existence of the node's consensus votes before joining consensus
 # When non-zero, the node will panic upon restart
 # if the same consensus key was used to sign {double-sign-check-height} last blocks.
@@ -499,6 +509,11 @@ max-open-connections = {{ .Instrumentation.MaxOpenConnections }}
 
 # Instrumentation namespace
 namespace = "{{ .Instrumentation.Namespace }}"
+
+# When true, the event bus query metrics are reported separately for each
+# query that clients subscribe with. Clients control the number of
+# distinct queries, and their series are never removed.
+query-labels = {{ .Instrumentation.QueryLabels }}
 `
 
 /****** these are for test settings ***********/
@@ -39,6 +39,9 @@ import (
 const (
 	testSubscriber = "test-client"
 
+	// client ID of subscriptions made with subscribeBuffered
+	bufferedSubscriber = "test-buffered-client"
+
 	// genesis, chain_id, priv_val
 	ensureTimeout = time.Millisecond * 200
 )
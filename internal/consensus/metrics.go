@@ -45,6 +45,9 @@ type Metrics struct {
 
 	// Time between this and the last block.
 	BlockIntervalSeconds metrics.Histogram
+	// Time from the time of a block until +2/3 of the voting power precommitted
+	// it, as observed by this node.
+	BlockCommitDelaySeconds metrics.Histogram
 
 	// Number of transactions.
 	NumTxs metrics.Gauge
@@ -64,6 +67,31 @@ type Metrics struct {
 
 	// Histogram of time taken per step annotated with reason that the step proceeded.
 	StepTime metrics.Histogram
+
+	// Time from the start of the round until the last part of the proposal
+	// block was received.
+	BlockPartsDeliverySeconds metrics.Histogram
+	// Time from the start of the round until the proposal was complete, i.e.
+	// both the proposal message and all of its block parts were received. This
+	// is the observed message delay of proposals.
+	ProposalDelaySeconds metrics.Histogram
+
+	// Number of proposals made by this node, by whether it proposed a fresh
+	// block or re-proposed the valid block of an earlier round.
+	ProposalDecisions metrics.Counter
+
+	// Estimated offset of the local clock from the clocks of the other
+	// validators: the median, over the validators whose votes were received at
+	// the current height, of the local time at receipt minus the vote
+	// timestamp. It includes the delay of the votes, so a positive value means
+	// the local clock is ahead or votes are slow to arrive, and a negative value
+	// means the local clock is behind.
+	ClockSkewSeconds metrics.Gauge
+
+	// Number of nil prevotes because no proposal block was received in time.
+	PrevoteNilTimeout metrics.Counter
+	// Number of nil prevotes because the proposal block was invalid.
+	PrevoteNilInvalid metrics.Counter
 }
 
 // PrometheusMetrics returns Metrics build using Prometheus client library.
@@ -148,6 +176,12 @@ func PrometheusMetrics(namespace string, labelsAndValues ...string) *Metrics {
 			Name:      "block_interval_seconds",
 			Help:      "Time between this and the last block.",
 		}, labels).With(labelsAndValues...),
+		BlockCommitDelaySeconds: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "block_commit_delay_seconds",
+			Help:      "Time from the time of a block until +2/3 precommits for it were received.",
+		}, labels).With(labelsAndValues...),
 		NumTxs: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
 			Namespace: namespace,
 			Subsystem: MetricsSubsystem,
@@ -196,6 +230,42 @@ func PrometheusMetrics(namespace string, labelsAndValues ...string) *Metrics {
 			Name:      "step_time",
 			Help:      "Time spent per step.",
 		}, append(labels, "step", "reason")).With(labelsAndValues...),
+		BlockPartsDeliverySeconds: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "block_parts_delivery_seconds",
+			Help:      "Time from the start of the round until the last part of the proposal block was received.",
+		}, labels).With(labelsAndValues...),
+		ProposalDelaySeconds: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "proposal_delay_seconds",
+			Help:      "Time from the start of the round until the proposal and its block were received.",
+		}, labels).With(labelsAndValues...),
+		ProposalDecisions: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "proposal_decisions_total",
+			Help:      "Number of proposals made, by whether the block was fresh or a re-proposal.",
+		}, append(labels, "decision")).With(labelsAndValues...),
+		ClockSkewSeconds: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "clock_skew_seconds",
+			Help:      "Median of local receive time minus timestamp of the votes of other validators.",
+		}, labels).With(labelsAndValues...),
+		PrevoteNilTimeout: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "prevote_nil_timeout_total",
+			Help:      "Number of nil prevotes because no proposal block was received in time.",
+		}, labels).With(labelsAndValues...),
+		PrevoteNilInvalid: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "prevote_nil_invalid_total",
+			Help:      "Number of nil prevotes because the proposal block was invalid.",
+		}, labels).With(labelsAndValues...),
 	}
 }
 
@@ -217,7 +287,8 @@ func NopMetrics() *Metrics {
 		ByzantineValidators:      discard.NewGauge(),
 		ByzantineValidatorsPower: discard.NewGauge(),
 
-		BlockIntervalSeconds: discard.NewHistogram(),
+		BlockIntervalSeconds:    discard.NewHistogram(),
+		BlockCommitDelaySeconds: discard.NewHistogram(),
 
 		NumTxs:          discard.NewGauge(),
 		BlockSizeBytes:  discard.NewHistogram(),
@@ -226,6 +297,13 @@ func NopMetrics() *Metrics {
 		BlockSyncing:    discard.NewGauge(),
 		StateSyncing:    discard.NewGauge(),
 		BlockParts:      discard.NewCounter(),
+
+		BlockPartsDeliverySeconds: discard.NewHistogram(),
+		ProposalDelaySeconds:      discard.NewHistogram(),
+		ProposalDecisions:         discard.NewCounter(),
+		ClockSkewSeconds:          discard.NewGauge(),
+		PrevoteNilTimeout:         discard.NewCounter(),
+		PrevoteNilInvalid:         discard.NewCounter(),
 	}
 }
 
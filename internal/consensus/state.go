@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"runtime/debug"
+	"sort"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
@@ -38,6 +39,8 @@ var (
 	ErrInvalidProposalPOLRound    = errors.New("error invalid proposal POL round")
 	ErrAddingVote                 = errors.New("error adding vote")
 	ErrSignatureFoundInPastBlocks = errors.New("found signature from the same key")
+	ErrHeightNotCommitted         = errors.New("height is not committed yet")
+	ErrVotesNotRetained           = errors.New("votes of height are not retained")
 
 	errPubKeyIsNotSet = errors.New("pubkey is not set. Look for \"Can't get private validator pubkey\" errors")
 )
@@ -144,6 +147,28 @@ type State struct {
 	// for reporting metrics
 	metrics *Metrics
 
+	// local time at which the current round was entered
+	roundStartTime time.Time
+
+	// durations of the steps, for adapting the timeouts if enabled
+	timeouts adaptiveTimeouts
+
+	// whether a propose, prevote or precommit timeout expired at this height
+	timedOut bool
+
+	// first failure observed in the current round, if any
+	roundFailure types.RoundFailureReason
+
+	// votes received at the last committed height, if it was committed by
+	// this state machine
+	lastVotes *cstypes.HeightVoteSet
+
+	// local receive time minus timestamp of the latest vote of each other
+	// validator at the current height, by validator address
+	voteOffsets map[string]time.Duration
+	// whether voteOffsets changed since the clock skew was last estimated
+	voteOffsetsChanged bool
+
 	// wait the channel event happening for shutting down the state gracefully
 	onStopCh chan *cstypes.RoundState
 }
@@ -234,6 +259,25 @@ func (cs *State) GetLastHeight() int64 {
 	return cs.RoundState.Height - 1
 }
 
+// CommittedVotes returns the prevotes and precommits of all rounds that this
+// node received at the given committed height, ordered by round, then with
+// prevotes before precommits, then by validator index. Only the votes of the
+// most recently committed height are retained, and only if it was committed
+// by this node's consensus rather than, e.g., by block sync. Other heights
+// return ErrHeightNotCommitted or ErrVotesNotRetained.
+func (cs *State) CommittedVotes(height int64) ([]*types.Vote, error) {
+	cs.mtx.RLock()
+	defer cs.mtx.RUnlock()
+
+	if height >= cs.Height {
+		return nil, fmt.Errorf("%w: %d", ErrHeightNotCommitted, height)
+	}
+	if cs.lastVotes == nil || cs.lastVotes.Height() != height {
+		return nil, fmt.Errorf("%w: %d", ErrVotesNotRetained, height)
+	}
+	return cs.lastVotes.Votes(), nil
+}
+
 // GetRoundState returns a shallow copy of the internal consensus state.
 func (cs *State) GetRoundState() *cstypes.RoundState {
 	cs.mtx.RLock()
@@ -267,6 +311,27 @@ func (cs *State) GetValidators() (int64, []*types.Validator) {
 	return cs.state.LastBlockHeight, cs.state.Validators.Copy().Validators
 }
 
+// UpcomingProposers returns the addresses of the proposers of the next n
+// rounds at the current height, starting with the current round: element i is
+// the proposer of round Round+i. Proposers are only known for the current
+// height, as those of later heights depend on the blocks committed until then,
+// so the rounds never extend past it.
+func (cs *State) UpcomingProposers(n int) []types.Address {
+	cs.mtx.RLock()
+	defer cs.mtx.RUnlock()
+
+	if n <= 0 || cs.Validators == nil {
+		return nil
+	}
+	validators := cs.Validators.Copy()
+	proposers := make([]types.Address, n)
+	for i := range proposers {
+		proposers[i] = validators.GetProposer().Address
+		validators.IncrementProposerPriority(1)
+	}
+	return proposers
+}
+
 // SetPrivValidator sets the private validator account for signing votes. It
 // immediately requests pubkey and caches it.
 func (cs *State) SetPrivValidator(priv types.PrivValidator) {
@@ -572,6 +637,7 @@ func (cs *State) updateHeight(height int64) {
 func (cs *State) updateRoundStep(round int32, step cstypes.RoundStepType) {
 	cs.Round = round
 	cs.Step = step
+	cs.timeouts.step(step, tmtime.Now())
 }
 
 // enterNewRound(height, 0) at cs.StartTime.
@@ -584,6 +650,17 @@ func (cs *State) scheduleRound0(rs *cstypes.RoundState) {
 // Attempt to schedule a timeout (by sending timeoutInfo on the tickChan)
 func (cs *State) scheduleTimeout(duration time.Duration, height int64, round int32, step cstypes.RoundStepType) {
 	cs.timeoutTicker.ScheduleTimeout(timeoutInfo{duration, height, round, step})
+
+	// let external watchdogs know when the step is expected to end
+	deadline := types.EventDataTimeoutDeadline{
+		Height:   height,
+		Round:    round,
+		Step:     step.String(),
+		Deadline: tmtime.Now().Add(duration),
+	}
+	if err := cs.eventBus.PublishEventTimeoutDeadline(deadline); err != nil {
+		cs.Logger.Error("failed publishing timeout deadline", "err", err)
+	}
 }
 
 // send a msg into the receiveRoutine regarding our own proposal, block part, or vote
@@ -721,10 +798,21 @@ func (cs *State) updateToState(state sm.State) {
 	cs.ValidRound = -1
 	cs.ValidBlock = nil
 	cs.ValidBlockParts = nil
+	if cs.Votes != nil && cs.Votes.Height() == state.LastBlockHeight {
+		cs.lastVotes = cs.Votes
+	} else {
+		cs.lastVotes = nil
+	}
 	cs.Votes = cstypes.NewHeightVoteSet(state.ChainID, height, validators)
 	cs.CommitRound = -1
 	cs.LastValidators = state.LastValidators
 	cs.TriggeredTimeoutPrecommit = false
+	cs.timedOut = false
+	cs.roundFailure = ""
+	// Estimate the clock skew from any votes of the last height not yet
+	// accounted for, before they are dropped.
+	cs.updateClockSkew()
+	cs.voteOffsets = make(map[string]time.Duration)
 
 	cs.state = state
 
@@ -739,12 +827,16 @@ func (cs *State) newStep() {
 	}
 
 	cs.nSteps++
+	cs.updateClockSkew()
 
 	// newStep is called by updateToState in NewState before the eventBus is set!
 	if cs.eventBus != nil {
 		if err := cs.eventBus.PublishEventNewRoundStep(rs); err != nil {
 			cs.Logger.Error("failed publishing new round step", "err", err)
 		}
+		if err := cs.eventBus.PublishEventRoundStep(cs.RoundStepEvent(tmtime.Now())); err != nil {
+			cs.Logger.Error("failed publishing round step", "err", err)
+		}
 
 		cs.evsw.FireEvent(types.EventNewRoundStepValue, &cs.RoundState)
 	}
@@ -949,6 +1041,8 @@ func (cs *State) handleTimeout(ti timeoutInfo, rs cstypes.RoundState) {
 		cs.enterPropose(ti.Height, 0)
 
 	case cstypes.RoundStepPropose:
+		cs.timedOut = true
+		cs.recordRoundFailure(types.RoundFailureProposeTimeout)
 		if err := cs.eventBus.PublishEventTimeoutPropose(cs.RoundStateEvent()); err != nil {
 			cs.Logger.Error("failed publishing timeout propose", "err", err)
 		}
@@ -956,6 +1050,8 @@ func (cs *State) handleTimeout(ti timeoutInfo, rs cstypes.RoundState) {
 		cs.enterPrevote(ti.Height, ti.Round)
 
 	case cstypes.RoundStepPrevoteWait:
+		cs.timedOut = true
+		cs.recordRoundFailure(types.RoundFailurePrevoteTimeout)
 		if err := cs.eventBus.PublishEventTimeoutWait(cs.RoundStateEvent()); err != nil {
 			cs.Logger.Error("failed publishing timeout wait", "err", err)
 		}
@@ -963,6 +1059,8 @@ func (cs *State) handleTimeout(ti timeoutInfo, rs cstypes.RoundState) {
 		cs.enterPrecommit(ti.Height, ti.Round)
 
 	case cstypes.RoundStepPrecommitWait:
+		cs.timedOut = true
+		cs.recordRoundFailure(types.RoundFailurePrecommitTimeout)
 		if err := cs.eventBus.PublishEventTimeoutWait(cs.RoundStateEvent()); err != nil {
 			cs.Logger.Error("failed publishing timeout wait", "err", err)
 		}
@@ -976,6 +1074,65 @@ func (cs *State) handleTimeout(ti timeoutInfo, rs cstypes.RoundState) {
 
 }
 
+// recordClockSkew records the offset of the local clock from the timestamp of
+// a vote received from another validator, from which the skew of the local
+// clock is estimated at the next step. Votes replayed from the WAL are
+// ignored, as they were received long before.
+func (cs *State) recordClockSkew(vote *types.Vote) {
+	if cs.replayMode {
+		return
+	}
+	cs.voteOffsets[vote.ValidatorAddress.String()] = tmtime.Now().Sub(vote.Timestamp)
+	cs.voteOffsetsChanged = true
+}
+
+// updateClockSkew sets the estimated skew of the local clock to the median of
+// the vote offsets recorded at the current height, if any were recorded since
+// it was last set. It is called once per step rather than per vote, as it
+// sorts the offsets of all validators.
+func (cs *State) updateClockSkew() {
+	if !cs.voteOffsetsChanged {
+		return
+	}
+	cs.voteOffsetsChanged = false
+
+	offsets := make([]time.Duration, 0, len(cs.voteOffsets))
+	for _, offset := range cs.voteOffsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	median := offsets[len(offsets)/2]
+	if len(offsets)%2 == 0 {
+		median = (offsets[len(offsets)/2-1] + median) / 2
+	}
+	cs.metrics.ClockSkewSeconds.Set(median.Seconds())
+}
+
+// recordRoundFailure records reason as the reason the current round failed,
+// unless one was already recorded.
+func (cs *State) recordRoundFailure(reason types.RoundFailureReason) {
+	if cs.roundFailure == "" {
+		cs.roundFailure = reason
+	}
+}
+
+// publishRoundFailure publishes the reason the current round failed, as the
+// state machine leaves it for a later round of the same height.
+func (cs *State) publishRoundFailure() {
+	reason := cs.roundFailure
+	if reason == "" {
+		reason = types.RoundFailureSkipped
+	}
+	cs.roundFailure = ""
+
+	cs.Logger.Debug("round failed", "height", cs.Height, "round", cs.Round, "reason", reason)
+	failure := types.EventDataRoundFailure{Height: cs.Height, Round: cs.Round, Reason: reason}
+	if err := cs.eventBus.PublishEventRoundFailure(failure); err != nil {
+		cs.Logger.Error("failed publishing round failure", "err", err)
+	}
+}
+
 func (cs *State) handleTxsAvailable() {
 	cs.mtx.Lock()
 	defer cs.mtx.Unlock()
@@ -1028,6 +1185,10 @@ func (cs *State) enterNewRound(height int64, round int32) {
 
 	logger.Debug("entering new round", "current", fmt.Sprintf("%v/%v/%v", cs.Height, cs.Round, cs.Step))
 
+	if cs.Round < round {
+		cs.publishRoundFailure()
+	}
+
 	// increment validators if necessary
 	validators := cs.Validators
 	if cs.Round < round {
@@ -1037,8 +1198,9 @@ func (cs *State) enterNewRound(height int64, round int32) {
 
 	// Setup new round
 	// we don't fire newStep for this step,
-	// but we fire an event, so update the round step first
+	// but we fire events, so update the round step first
 	cs.updateRoundStep(round, cstypes.RoundStepNewRound)
+	cs.roundStartTime = tmtime.Now()
 	cs.Validators = validators
 	if round == 0 {
 		// We've already reset these upon new height,
@@ -1057,6 +1219,9 @@ func (cs *State) enterNewRound(height int64, round int32) {
 	if err := cs.eventBus.PublishEventNewRound(cs.NewRoundEvent()); err != nil {
 		cs.Logger.Error("failed publishing new round", "err", err)
 	}
+	if err := cs.eventBus.PublishEventRoundStep(cs.RoundStepEvent(cs.roundStartTime)); err != nil {
+		cs.Logger.Error("failed publishing round step", "err", err)
+	}
 
 	cs.metrics.Rounds.Set(float64(round))
 
@@ -1106,9 +1271,17 @@ func (cs *State) enterPropose(height int64, round int32) {
 
 	logger.Debug("entering propose step", "current", fmt.Sprintf("%v/%v/%v", cs.Height, cs.Round, cs.Step))
 
+	// whether this node proposes in this round
+	var proposer bool
+
 	defer func() {
 		// Done enterPropose:
 		cs.updateRoundStep(round, cstypes.RoundStepPropose)
+		if proposer {
+			// Our own proposal is ready at once, which tells nothing of how
+			// long the proposals of others take to arrive.
+			cs.timeouts.skip()
+		}
 		cs.newStep()
 
 		// If we have the whole proposal + POL, then goto Prevote now.
@@ -1120,7 +1293,7 @@ func (cs *State) enterPropose(height int64, round int32) {
 	}()
 
 	// If we don't get the proposal and all block parts quick enough, enterPrevote
-	cs.scheduleTimeout(cs.config.Propose(round), height, round, cstypes.RoundStepPropose)
+	cs.scheduleTimeout(cs.timeouts.Propose(cs.config, round), height, round, cstypes.RoundStepPropose)
 
 	// Nothing more to do if we're not a validator
 	if cs.privValidator == nil {
@@ -1146,6 +1319,7 @@ func (cs *State) enterPropose(height int64, round int32) {
 	}
 
 	if cs.isProposer(address) {
+		proposer = true
 		logger.Debug(
 			"propose step; our turn to propose",
 			"proposer", address,
@@ -1164,6 +1338,12 @@ func (cs *State) isProposer(address []byte) bool {
 	return bytes.Equal(cs.Validators.GetProposer().Address, address)
 }
 
+// isLocalProposer reports whether this node is the proposer of the current
+// round, whose proposal and block parts it has without waiting on the network.
+func (cs *State) isLocalProposer() bool {
+	return cs.privValidatorPubKey != nil && cs.isProposer(cs.privValidatorPubKey.Address())
+}
+
 func (cs *State) defaultDecideProposal(height int64, round int32) {
 	var block *types.Block
 	var blockParts *types.PartSet
@@ -1172,12 +1352,16 @@ func (cs *State) defaultDecideProposal(height int64, round int32) {
 	if cs.ValidBlock != nil {
 		// If there is valid block, choose that.
 		block, blockParts = cs.ValidBlock, cs.ValidBlockParts
+		cs.Logger.Debug("re-proposing valid block", "height", height, "round", round, "valid_round", cs.ValidRound)
+		cs.metrics.ProposalDecisions.With("decision", "reproposal").Add(1)
 	} else {
 		// Create a new proposal block from state/txs from the mempool.
 		block, blockParts = cs.createProposalBlock()
 		if block == nil {
 			return
 		}
+		cs.Logger.Debug("proposing fresh block", "height", height, "round", round)
+		cs.metrics.ProposalDecisions.With("decision", "fresh").Add(1)
 	}
 
 	// Flush the WAL. Otherwise, we may not recompute the same proposal to sign,
@@ -1297,12 +1481,33 @@ func (cs *State) enterPrevote(height int64, round int32) {
 	// (so we have more time to try and collect +2/3 prevotes for a single block)
 }
 
+// Reasons logged by defaultDoPrevote for the block it prevotes. A node only
+// ever accepts the first valid proposal of a round (see defaultSetProposal),
+// so the only choice to make is between that proposal and a locked block.
+const (
+	prevoteReasonLocked    = "locked block"
+	prevoteReasonFirstSeen = "first-seen proposal"
+)
+
 func (cs *State) defaultDoPrevote(height int64, round int32) {
 	logger := cs.Logger.With("height", height, "round", round)
 
+	// The proposal competing with the locked block, if any, and its POL round.
+	proposalBlockID, polRound := types.BlockID{}, int32(-1)
+	if cs.Proposal != nil {
+		proposalBlockID, polRound = cs.Proposal.BlockID, cs.Proposal.POLRound
+	}
+
 	// If a block is locked, prevote that.
 	if cs.LockedBlock != nil {
-		logger.Debug("prevote step; already locked on a block; prevoting locked block")
+		logger.Debug(
+			"prevote step; already locked on a block; prevoting locked block",
+			"reason", prevoteReasonLocked,
+			"locked_block", types.BlockID{Hash: cs.LockedBlock.Hash(), PartSetHeader: cs.LockedBlockParts.Header()},
+			"locked_round", cs.LockedRound,
+			"proposal_block", proposalBlockID,
+			"pol_round", polRound,
+		)
 		cs.signAddVote(tmproto.PrevoteType, cs.LockedBlock.Hash(), cs.LockedBlockParts.Header())
 		return
 	}
@@ -1310,6 +1515,7 @@ func (cs *State) defaultDoPrevote(height int64, round int32) {
 	// If ProposalBlock is nil, prevote nil.
 	if cs.ProposalBlock == nil {
 		logger.Debug("prevote step: ProposalBlock is nil")
+		cs.metrics.PrevoteNilTimeout.Add(1)
 		cs.signAddVote(tmproto.PrevoteType, nil, types.PartSetHeader{})
 		return
 	}
@@ -1319,6 +1525,7 @@ func (cs *State) defaultDoPrevote(height int64, round int32) {
 	if err != nil {
 		// ProposalBlock is invalid, prevote nil.
 		logger.Error("prevote step: ProposalBlock is invalid", "err", err)
+		cs.metrics.PrevoteNilInvalid.Add(1)
 		cs.signAddVote(tmproto.PrevoteType, nil, types.PartSetHeader{})
 		return
 	}
@@ -1326,7 +1533,12 @@ func (cs *State) defaultDoPrevote(height int64, round int32) {
 	// Prevote cs.ProposalBlock
 	// NOTE: the proposal signature is validated when it is received,
 	// and the proposal block parts are validated as they are received (against the merkle hash in the proposal)
-	logger.Debug("prevote step: ProposalBlock is valid")
+	logger.Debug(
+		"prevote step: ProposalBlock is valid",
+		"reason", prevoteReasonFirstSeen,
+		"proposal_block", proposalBlockID,
+		"pol_round", polRound,
+	)
 	cs.signAddVote(tmproto.PrevoteType, cs.ProposalBlock.Hash(), cs.ProposalBlockParts.Header())
 }
 
@@ -1358,7 +1570,7 @@ func (cs *State) enterPrevoteWait(height int64, round int32) {
 	}()
 
 	// Wait for some more prevotes; enterPrecommit
-	cs.scheduleTimeout(cs.config.Prevote(round), height, round, cstypes.RoundStepPrevoteWait)
+	cs.scheduleTimeout(cs.timeouts.Prevote(cs.config, round), height, round, cstypes.RoundStepPrevoteWait)
 }
 
 // Enter: `timeoutPrevote` after any +2/3 prevotes.
@@ -1414,6 +1626,7 @@ func (cs *State) enterPrecommit(height int64, round int32) {
 
 	// +2/3 prevoted nil. Unlock and precommit nil.
 	if len(blockID.Hash) == 0 {
+		cs.recordRoundFailure(types.RoundFailureNilPrevoteQuorum)
 		if cs.LockedBlock == nil {
 			logger.Debug("precommit step; +2/3 prevoted for nil")
 		} else {
@@ -1513,11 +1726,14 @@ func (cs *State) enterPrecommitWait(height int64, round int32) {
 	defer func() {
 		// Done enterPrecommitWait:
 		cs.TriggeredTimeoutPrecommit = true
+		// The step stays RoundStepPrecommit, so tell the adaptive timeouts
+		// that the wait bounded by the precommit timeout starts here.
+		cs.timeouts.step(cstypes.RoundStepPrecommitWait, tmtime.Now())
 		cs.newStep()
 	}()
 
 	// wait for some more precommits; enterNewRound
-	cs.scheduleTimeout(cs.config.Precommit(round), height, round, cstypes.RoundStepPrecommitWait)
+	cs.scheduleTimeout(cs.timeouts.Precommit(cs.config, round), height, round, cstypes.RoundStepPrecommitWait)
 }
 
 // Enter: +2/3 precommits for block
@@ -1639,6 +1855,7 @@ func (cs *State) finalizeCommit(height int64) {
 	if err := cs.blockExec.ValidateBlock(cs.state, block); err != nil {
 		panic(fmt.Errorf("+2/3 committed an invalid block: %w", err))
 	}
+	cs.checkBlockTimeIncreasing(block)
 
 	logger.Info(
 		"finalizing commit of block",
@@ -1709,6 +1926,9 @@ func (cs *State) finalizeCommit(height int64) {
 
 	// must be called before we update state
 	cs.RecordMetrics(height, block)
+	if err := cs.eventBus.PublishEventHeightSummary(cs.heightSummary(block)); err != nil {
+		logger.Error("failed publishing height summary", "err", err)
+	}
 
 	// NewHeightStep!
 	cs.updateToState(stateCopy)
@@ -1730,6 +1950,29 @@ func (cs *State) finalizeCommit(height int64) {
 	// * cs.StartTime is set to when we will start round0.
 }
 
+// heightSummary summarizes the height at which block is being committed.
+func (cs *State) heightSummary(block *types.Block) types.EventDataHeightSummary {
+	count := func(votes *types.VoteSet) int {
+		n := 0
+		for i := 0; i < votes.Size(); i++ {
+			if votes.GetByIndex(int32(i)) != nil {
+				n++
+			}
+		}
+		return n
+	}
+	return types.EventDataHeightSummary{
+		Height:      block.Height,
+		BlockTime:   block.Time,
+		Proposer:    block.ProposerAddress,
+		CommitRound: cs.CommitRound,
+		Prevotes:    count(cs.Votes.Prevotes(cs.CommitRound)),
+		Precommits:  count(cs.Votes.Precommits(cs.CommitRound)),
+		TimedOut:    cs.timedOut,
+		CommitTime:  cs.CommitTime,
+	}
+}
+
 func (cs *State) RecordMetrics(height int64, block *types.Block) {
 	cs.metrics.Validators.Set(float64(cs.Validators.Size()))
 	cs.metrics.ValidatorsPower.Set(float64(cs.Validators.TotalVotingPower()))
@@ -1813,6 +2056,9 @@ func (cs *State) RecordMetrics(height int64, block *types.Block) {
 			)
 		}
 	}
+	if !cs.CommitTime.IsZero() {
+		cs.metrics.BlockCommitDelaySeconds.Observe(cs.CommitTime.Sub(block.Time).Seconds())
+	}
 
 	cs.metrics.NumTxs.Set(float64(len(block.Data.Txs)))
 	cs.metrics.TotalTxs.Add(float64(len(block.Data.Txs)))
@@ -1850,6 +2096,9 @@ func (cs *State) defaultSetProposal(proposal *types.Proposal) error {
 
 	proposal.Signature = p.Signature
 	cs.Proposal = proposal
+	if cs.ProposalBlock != nil {
+		cs.recordProposalDelay()
+	}
 	// We don't update cs.ProposalBlockParts if it is already set.
 	// This happens if we're already in cstypes.RoundStepCommit or if there is a valid block in the current round.
 	// TODO: We can check if Proposal is for a different block as this is a sign of misbehavior!
@@ -1861,6 +2110,16 @@ func (cs *State) defaultSetProposal(proposal *types.Proposal) error {
 	return nil
 }
 
+// recordProposalDelay records the time elapsed since the start of the round,
+// once both the proposal and its block have been received. Proposals of this
+// node are not recorded, as they take no time to arrive.
+func (cs *State) recordProposalDelay() {
+	if cs.isLocalProposer() {
+		return
+	}
+	cs.metrics.ProposalDelaySeconds.Observe(tmtime.Now().Sub(cs.roundStartTime).Seconds())
+}
+
 // NOTE: block is not necessarily valid.
 // Asynchronously triggers either enterPrevote (before we timeout of propose) or tryFinalizeCommit,
 // once we have the full block.
@@ -1914,6 +2173,14 @@ func (cs *State) addProposalBlockPart(msg *BlockPartMessage, peerID types.NodeID
 		}
 
 		cs.ProposalBlock = block
+		if round == cs.Round {
+			if !cs.isLocalProposer() {
+				cs.metrics.BlockPartsDeliverySeconds.Observe(tmtime.Now().Sub(cs.roundStartTime).Seconds())
+			}
+			if cs.Proposal != nil && cs.Proposal.Round == round {
+				cs.recordProposalDelay()
+			}
+		}
 
 		// NOTE: it's possible to receive complete proposal blocks for future rounds without having the proposal
 		cs.Logger.Info("received complete proposal block", "height", cs.ProposalBlock.Height, "hash", cs.ProposalBlock.Hash())
@@ -1961,6 +2228,26 @@ func (cs *State) addProposalBlockPart(msg *BlockPartMessage, peerID types.NodeID
 	return added, nil
 }
 
+// checkBlockTimeIncreasing panics unless the time of block is after that of
+// the previous block in the block store. ValidateBlock checks the time against
+// the state, so this is a safety net for a consensus bug or a state that is
+// inconsistent with the block store.
+func (cs *State) checkBlockTimeIncreasing(block *types.Block) {
+	if block.Height <= cs.state.InitialHeight {
+		return
+	}
+	meta := cs.blockStore.LoadBlockMeta(block.Height - 1)
+	if meta == nil {
+		return // e.g. after state sync
+	}
+	if !block.Time.After(meta.Header.Time) {
+		panic(fmt.Sprintf(
+			"committing block %d with time %v, which is not after the time %v of block %d",
+			block.Height, block.Time, meta.Header.Time, meta.Header.Height,
+		))
+	}
+}
+
 // Attempt to add the vote. if its a duplicate signature, dupeout the validator
 func (cs *State) tryAddVote(vote *types.Vote, peerID types.NodeID) (bool, error) {
 	added, err := cs.addVote(vote, peerID)
@@ -2063,6 +2350,9 @@ func (cs *State) addVote(vote *types.Vote, peerID types.NodeID) (added bool, err
 		// Either duplicate, or error upon cs.Votes.AddByIndex()
 		return
 	}
+	if cs.privValidatorPubKey == nil || !bytes.Equal(vote.ValidatorAddress, cs.privValidatorPubKey.Address()) {
+		cs.recordClockSkew(vote)
+	}
 
 	if err := cs.eventBus.PublishEventVote(types.EventDataVote{Vote: vote}); err != nil {
 		return added, err
@@ -4,9 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/metrics/generic"
+	kitprom "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -152,6 +157,37 @@ func TestStateProposerSelection2(t *testing.T) {
 
 }
 
+func TestStateUpcomingProposers(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 4)
+	require.NoError(t, err)
+
+	addresses := make([]types.Address, len(vss))
+	for i, vs := range vss {
+		pubKey, err := vs.GetPubKey(ctx)
+		require.NoError(t, err)
+		addresses[i] = pubKey.Address()
+	}
+
+	assert.Nil(t, cs1.UpcomingProposers(0))
+
+	// The validators have equal power, so they propose in turn.
+	proposers := cs1.UpcomingProposers(6)
+	require.Len(t, proposers, 6)
+	for i, proposer := range proposers {
+		assert.Equal(t, addresses[i%len(vss)], proposer, "round %d", i)
+	}
+
+	// Once in a later round, the proposers start from it.
+	newRoundCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewRound)
+	startTestRound(ctx, cs1, cs1.Height, 2)
+	ensureNewRound(newRoundCh, cs1.Height, 2)
+	assert.Equal(t, []types.Address{addresses[2], addresses[3], addresses[0]}, cs1.UpcomingProposers(3))
+}
+
 // a non-validator should timeout into the prevote round
 func TestStateEnterProposeNoPrivValidator(t *testing.T) {
 	config := configSetup(t)
@@ -1228,6 +1264,11 @@ func TestProposeValidBlock(t *testing.T) {
 	vs2, vs3, vs4 := vss[1], vss[2], vss[3]
 	height, round := cs1.Height, cs1.Round
 
+	proposalDecisions := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+		Name: "proposal_decisions_total",
+	}, []string{"decision"})
+	cs1.metrics.ProposalDecisions = kitprom.NewCounter(proposalDecisions)
+
 	partSize := types.BlockPartSizeBytes
 
 	proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
@@ -1311,6 +1352,12 @@ func TestProposeValidBlock(t *testing.T) {
 	assert.True(t, bytes.Equal(rs.ProposalBlock.Hash(), rs.ValidBlock.Hash()))
 	assert.True(t, rs.Proposal.POLRound == rs.ValidRound)
 	assert.True(t, bytes.Equal(rs.Proposal.BlockID.Hash, rs.ValidBlock.Hash()))
+
+	// The block was proposed fresh in round 0 and re-proposed in round 4, with
+	// round 0 as its original round.
+	assert.Equal(t, int32(0), rs.Proposal.POLRound)
+	assert.Equal(t, 1.0, testutil.ToFloat64(proposalDecisions.WithLabelValues("fresh")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(proposalDecisions.WithLabelValues("reproposal")))
 }
 
 // What we want:
@@ -2085,6 +2132,747 @@ func TestSignSameVoteTwice(t *testing.T) {
 	require.Equal(t, vote, vote2)
 }
 
+func TestStateRecordsBlockPartsDelivery(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+	vs2 := vss[1]
+	height, round := cs1.Height, cs1.Round+1
+	incrementRound(vss[1:]...)
+
+	delivery := generic.NewHistogram("block_parts_delivery_seconds", 10)
+	cs1.metrics.BlockPartsDeliverySeconds = delivery
+
+	proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
+
+	propBlock, _ := cs1.createProposalBlock()
+	propBlockParts := propBlock.MakePartSet(types.BlockPartSizeBytes)
+	blockID := types.BlockID{Hash: propBlock.Hash(), PartSetHeader: propBlockParts.Header()}
+	proposal := types.NewProposal(vs2.Height, round, -1, blockID)
+	p := proposal.ToProto()
+	require.NoError(t, vs2.SignProposal(ctx, config.ChainID(), p))
+	proposal.Signature = p.Signature
+
+	// deliver the block some time after the round starts, but before the
+	// propose step times out
+	const delay = 15 * time.Millisecond
+	startTestRound(ctx, cs1, height, round)
+	time.Sleep(delay)
+	require.NoError(t, cs1.SetProposalAndBlock(proposal, propBlock, propBlockParts, "some peer"))
+	ensureProposal(proposalCh, height, round, blockID)
+
+	elapsed := delivery.Quantile(0.5)
+	assert.GreaterOrEqual(t, elapsed, delay.Seconds())
+	assert.Less(t, elapsed, cs1.config.Propose(round).Seconds())
+}
+
+func TestStateSkipsOwnProposalTiming(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, _, err := randState(ctx, config, log.TestingLogger(), 1)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	delivery := generic.NewHistogram("block_parts_delivery_seconds", 10)
+	cs1.metrics.BlockPartsDeliverySeconds = delivery
+	proposalDelay := generic.NewHistogram("proposal_delay_seconds", 10)
+	cs1.metrics.ProposalDelaySeconds = proposalDelay
+
+	proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
+
+	// cs1 is the only validator, so it proposes and has its block at once.
+	startTestRound(ctx, cs1, height, round)
+	ensureNewProposal(proposalCh, height, round)
+
+	// Quantiles of an empty histogram are -1.
+	assert.Equal(t, -1.0, delivery.Quantile(0.5), "block parts delivery")
+	assert.Equal(t, -1.0, proposalDelay.Quantile(0.5), "proposal delay")
+}
+
+func TestStateRecordsProposalDelay(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+	vs2 := vss[1]
+	height, round := cs1.Height, cs1.Round+1
+	incrementRound(vss[1:]...)
+
+	proposalDelay := generic.NewHistogram("proposal_delay_seconds", 10)
+	cs1.metrics.ProposalDelaySeconds = proposalDelay
+
+	proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
+
+	propBlock, _ := cs1.createProposalBlock()
+	propBlockParts := propBlock.MakePartSet(types.BlockPartSizeBytes)
+	blockID := types.BlockID{Hash: propBlock.Hash(), PartSetHeader: propBlockParts.Header()}
+	proposal := types.NewProposal(vs2.Height, round, -1, blockID)
+	p := proposal.ToProto()
+	require.NoError(t, vs2.SignProposal(ctx, config.ChainID(), p))
+	proposal.Signature = p.Signature
+
+	// deliver the proposal some time after the round starts, but before the
+	// propose step times out
+	const delay = 15 * time.Millisecond
+	startTestRound(ctx, cs1, height, round)
+	time.Sleep(delay)
+	require.NoError(t, cs1.SetProposalAndBlock(proposal, propBlock, propBlockParts, "some peer"))
+	ensureProposal(proposalCh, height, round, blockID)
+
+	elapsed := proposalDelay.Quantile(0.5)
+	assert.GreaterOrEqual(t, elapsed, delay.Seconds())
+	assert.Less(t, elapsed, cs1.config.Propose(round).Seconds())
+}
+
+func TestStateRoundStepEvents(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, _, err := randState(ctx, config, log.TestingLogger(), 1)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	// The steps follow one another quickly, so buffer them.
+	sub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryRoundStep)
+
+	startTestRound(ctx, cs1, height, round)
+
+	expected := []cstypes.RoundStepType{
+		cstypes.RoundStepNewRound,
+		cstypes.RoundStepPropose,
+		cstypes.RoundStepPrevote,
+		cstypes.RoundStepPrecommit,
+		cstypes.RoundStepCommit,
+	}
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+
+	var last time.Time
+	for _, step := range expected {
+		msg, err := sub.Next(tctx)
+		require.NoError(t, err)
+		event, ok := msg.Data().(types.EventDataRoundStep)
+		require.True(t, ok, "expected EventDataRoundStep, got %T", msg.Data())
+
+		assert.Equal(t, height, event.Height)
+		assert.Equal(t, round, event.Round)
+		assert.Equal(t, step.String(), event.Step)
+		assert.False(t, event.Time.Before(last), "step %v entered before the previous one", step)
+		last = event.Time
+	}
+
+	// After the commit, the next height begins.
+	msg, err := sub.Next(tctx)
+	require.NoError(t, err)
+	event := msg.Data().(types.EventDataRoundStep)
+	assert.Equal(t, height+1, event.Height)
+	assert.Equal(t, cstypes.RoundStepNewHeight.String(), event.Step)
+}
+
+func TestStateTimeoutDeadlineEvents(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round+1 // the other validator proposes
+	incrementRound(vss[1:]...)
+
+	stepSub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryRoundStep)
+	deadlineSub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryTimeoutDeadline)
+
+	startTestRound(ctx, cs1, height, round)
+
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+
+	msg, err := stepSub.Next(tctx)
+	require.NoError(t, err)
+	newRound := msg.Data().(types.EventDataRoundStep)
+	require.Equal(t, cstypes.RoundStepNewRound.String(), newRound.Step)
+
+	msg, err = deadlineSub.Next(tctx)
+	require.NoError(t, err)
+	deadline := msg.Data().(types.EventDataTimeoutDeadline)
+	assert.Equal(t, height, deadline.Height)
+	assert.Equal(t, round, deadline.Round)
+	assert.Equal(t, cstypes.RoundStepPropose.String(), deadline.Step)
+
+	// The propose step is entered right after the round starts.
+	expected := newRound.Time.Add(cs1.config.Propose(round))
+	assert.False(t, deadline.Deadline.Before(expected), "deadline %v before %v", deadline.Deadline, expected)
+	assert.WithinDuration(t, expected, deadline.Deadline, 50*time.Millisecond)
+}
+
+func TestStateBlockTimeIncreasing(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, _, err := randState(ctx, config, log.TestingLogger(), 1)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	newBlockCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewBlock)
+	startTestRound(ctx, cs1, height, round)
+	ensureNewBlock(newBlockCh, height)
+
+	cs1.mtx.Lock()
+	defer cs1.mtx.Unlock()
+	committed := cs1.blockStore.LoadBlockMeta(height).Header.Time
+
+	next := &types.Block{Header: types.Header{Height: height + 1}}
+	for _, blockTime := range []time.Time{committed, committed.Add(-time.Second)} {
+		next.Time = blockTime
+		assert.Panics(t, func() { cs1.checkBlockTimeIncreasing(next) }, "time %v", blockTime)
+	}
+	next.Time = committed.Add(time.Millisecond)
+	assert.NotPanics(t, func() { cs1.checkBlockTimeIncreasing(next) })
+}
+
+func TestStatePrevoteNilMetrics(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// newState returns a state with two validators, the other of which
+	// proposes in the returned round.
+	newState := func(t *testing.T) (*State, []*validatorStub, int32, *generic.Counter, *generic.Counter) {
+		cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+		require.NoError(t, err)
+		nilTimeout := generic.NewCounter("prevote_nil_timeout_total")
+		nilInvalid := generic.NewCounter("prevote_nil_invalid_total")
+		cs1.metrics.PrevoteNilTimeout = nilTimeout
+		cs1.metrics.PrevoteNilInvalid = nilInvalid
+
+		incrementRound(vss[1:]...)
+		return cs1, vss, cs1.Round + 1, nilTimeout, nilInvalid
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		cs1, vss, round, nilTimeout, nilInvalid := newState(t)
+		height := cs1.Height
+		voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+
+		// no proposal arrives
+		startTestRound(ctx, cs1, height, round)
+		ensurePrevote(voteCh, height, round)
+		validatePrevote(ctx, t, cs1, round, vss[0], nil)
+
+		assert.Equal(t, 1.0, nilTimeout.Value())
+		assert.Equal(t, 0.0, nilInvalid.Value())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cs1, vss, round, nilTimeout, nilInvalid := newState(t)
+		height := cs1.Height
+		vs2 := vss[1]
+		proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
+		voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+
+		// make the block bad by tampering with its app hash
+		propBlock, _ := cs1.createProposalBlock()
+		propBlock.AppHash = tmrand.Bytes(32)
+		propBlockParts := propBlock.MakePartSet(types.BlockPartSizeBytes)
+		blockID := types.BlockID{Hash: propBlock.Hash(), PartSetHeader: propBlockParts.Header()}
+		proposal := types.NewProposal(vs2.Height, round, -1, blockID)
+		p := proposal.ToProto()
+		require.NoError(t, vs2.SignProposal(ctx, config.ChainID(), p))
+		proposal.Signature = p.Signature
+		require.NoError(t, cs1.SetProposalAndBlock(proposal, propBlock, propBlockParts, "some peer"))
+
+		startTestRound(ctx, cs1, height, round)
+		ensureProposal(proposalCh, height, round, blockID)
+		ensurePrevote(voteCh, height, round)
+		validatePrevote(ctx, t, cs1, round, vss[0], nil)
+
+		assert.Equal(t, 0.0, nilTimeout.Value())
+		assert.Equal(t, 1.0, nilInvalid.Value())
+	})
+}
+
+func TestStateHeightSummary(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+	vs2 := vss[1]
+	height, round := cs1.Height, cs1.Round
+
+	voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+	newRoundCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewRound)
+	blockSub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryNewBlock)
+	summarySub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryHeightSummary)
+
+	startTestRound(ctx, cs1, height, round)
+	ensureNewRound(newRoundCh, height, round)
+
+	ensurePrevote(voteCh, height, round)
+	rs := cs1.GetRoundState()
+	propBlockHash, propPartSetHeader := rs.ProposalBlock.Hash(), rs.ProposalBlockParts.Header()
+	signAddVotes(ctx, config, cs1, tmproto.PrevoteType, propBlockHash, propPartSetHeader, vs2)
+	ensurePrevote(voteCh, height, round)
+
+	ensurePrecommit(voteCh, height, round)
+	signAddVotes(ctx, config, cs1, tmproto.PrecommitType, propBlockHash, propPartSetHeader, vs2)
+	ensurePrecommit(voteCh, height, round)
+
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+
+	msg, err := blockSub.Next(tctx)
+	require.NoError(t, err)
+	block := msg.Data().(types.EventDataNewBlock).Block
+
+	msg, err = summarySub.Next(tctx)
+	require.NoError(t, err)
+	summary := msg.Data().(types.EventDataHeightSummary)
+	assert.Equal(t, height, summary.Height)
+	assert.Equal(t, block.Time, summary.BlockTime)
+	assert.Equal(t, block.ProposerAddress, summary.Proposer)
+	assert.Equal(t, round, summary.CommitRound)
+	assert.Equal(t, 2, summary.Prevotes)
+	assert.Equal(t, 2, summary.Precommits)
+	assert.False(t, summary.TimedOut)
+	assert.False(t, summary.CommitTime.IsZero())
+}
+
+func TestStateHeightSummaryTimedOut(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, _, err := randState(ctx, config, log.TestingLogger(), 1)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	// Withhold the proposal of the first round, so that it times out.
+	cs1.decideProposal = func(height int64, round int32) {
+		if round > 0 {
+			cs1.defaultDecideProposal(height, round)
+		}
+	}
+
+	summarySub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryHeightSummary)
+
+	startTestRound(ctx, cs1, height, round)
+
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+
+	msg, err := summarySub.Next(tctx)
+	require.NoError(t, err)
+	summary := msg.Data().(types.EventDataHeightSummary)
+	assert.Equal(t, height, summary.Height)
+	assert.Equal(t, round+1, summary.CommitRound)
+	assert.Equal(t, 1, summary.Prevotes)
+	assert.Equal(t, 1, summary.Precommits)
+	assert.True(t, summary.TimedOut)
+}
+
+func TestStateRoundFailureProposeTimeout(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, _, err := randState(ctx, config, log.TestingLogger(), 1)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	// Withhold the proposal of the first round, so that it times out.
+	cs1.decideProposal = func(h int64, r int32) {
+		if h != height || r > 0 {
+			cs1.defaultDecideProposal(h, r)
+		}
+	}
+
+	failureSub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryRoundFailure)
+	newBlockCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewBlock)
+
+	startTestRound(ctx, cs1, height, round)
+	ensureNewBlock(newBlockCh, height)
+
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+
+	msg, err := failureSub.Next(tctx)
+	require.NoError(t, err)
+	assert.Equal(t, types.EventDataRoundFailure{
+		Height: height,
+		Round:  round,
+		Reason: types.RoundFailureProposeTimeout,
+	}, msg.Data())
+
+	// The round in which the block was committed did not fail.
+	tctx, tcancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer tcancel()
+	_, err = failureSub.Next(tctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestStateRecordsClockSkew(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 4)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	clockSkew := generic.NewGauge("clock_skew_seconds")
+	cs1.metrics.ClockSkewSeconds = clockSkew
+
+	voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+
+	startTestRound(ctx, cs1, height, round)
+	ensurePrevote(voteCh, height, round) // our own prevote
+
+	// The other validators prevote nil with clocks behind ours by 1s, 2s and
+	// 3s, so our clock appears to be 2s ahead.
+	for i, vs := range vss[1:] {
+		pubKey, err := vs.GetPubKey(ctx)
+		require.NoError(t, err)
+		vote := &types.Vote{
+			ValidatorIndex:   vs.Index,
+			ValidatorAddress: pubKey.Address(),
+			Height:           height,
+			Round:            round,
+			Timestamp:        time.Now().Add(-time.Duration(i+1) * time.Second),
+			Type:             tmproto.PrevoteType,
+		}
+		v := vote.ToProto()
+		require.NoError(t, vs.SignVote(ctx, config.ChainID(), v))
+		vote.Signature = v.Signature
+		addVotes(cs1, vote)
+		ensurePrevote(voteCh, height, round)
+	}
+
+	// The skew is estimated at the step the last vote leads to.
+	require.Eventually(t, func() bool {
+		skew := time.Duration(clockSkew.Value() * float64(time.Second))
+		return skew >= 2*time.Second && skew < 2*time.Second+100*time.Millisecond
+	}, ensureTimeout, 10*time.Millisecond)
+}
+
+func TestStateSkipsClockSkewInReplay(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+
+	clockSkew := generic.NewGauge("clock_skew_seconds")
+	cs1.metrics.ClockSkewSeconds = clockSkew
+
+	pubKey, err := vss[1].GetPubKey(ctx)
+	require.NoError(t, err)
+	vote := &types.Vote{
+		ValidatorAddress: pubKey.Address(),
+		Timestamp:        time.Now().Add(-time.Hour),
+	}
+
+	// A vote replayed from the WAL was received long after its timestamp.
+	cs1.replayMode = true
+	cs1.recordClockSkew(vote)
+	cs1.updateClockSkew()
+	assert.Zero(t, clockSkew.Value())
+
+	cs1.replayMode = false
+	cs1.recordClockSkew(vote)
+	cs1.updateClockSkew()
+	assert.InDelta(t, time.Hour.Seconds(), clockSkew.Value(), 1)
+}
+
+func TestStateRecordsBlockCommitDelay(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, _, err := randState(ctx, config, log.TestingLogger(), 1)
+	require.NoError(t, err)
+	height, round := cs1.Height, cs1.Round
+
+	commitDelay := generic.NewHistogram("block_commit_delay_seconds", 10)
+	cs1.metrics.BlockCommitDelaySeconds = commitDelay
+
+	stepSub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryRoundStep)
+	summarySub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryHeightSummary)
+
+	startTestRound(ctx, cs1, height, round)
+
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+
+	msg, err := summarySub.Next(tctx)
+	require.NoError(t, err)
+	summary := msg.Data().(types.EventDataHeightSummary)
+
+	// The commit step is entered as soon as the precommit quorum forms.
+	var commitStep types.EventDataRoundStep
+	for commitStep.Step != cstypes.RoundStepCommit.String() {
+		msg, err := stepSub.Next(tctx)
+		require.NoError(t, err)
+		commitStep = msg.Data().(types.EventDataRoundStep)
+	}
+
+	delay := commitDelay.Quantile(0.5)
+	assert.Equal(t, summary.CommitTime.Sub(summary.BlockTime).Seconds(), delay)
+	assert.InDelta(t, commitStep.Time.Sub(summary.BlockTime).Seconds(), delay, 0.01)
+}
+
+func TestStateCommittedVotes(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+	vs2 := vss[1]
+	height, round := cs1.Height, cs1.Round
+
+	voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+	newBlockCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewBlock)
+	voteSub := subscribeBuffered(ctx, t, cs1.eventBus, types.EventQueryVote)
+
+	_, err = cs1.CommittedVotes(height)
+	require.ErrorIs(t, err, ErrHeightNotCommitted)
+
+	startTestRound(ctx, cs1, height, round)
+
+	ensurePrevote(voteCh, height, round)
+	rs := cs1.GetRoundState()
+	propBlockHash, propPartSetHeader := rs.ProposalBlock.Hash(), rs.ProposalBlockParts.Header()
+	signAddVotes(ctx, config, cs1, tmproto.PrevoteType, propBlockHash, propPartSetHeader, vs2)
+	ensurePrevote(voteCh, height, round)
+
+	ensurePrecommit(voteCh, height, round)
+	signAddVotes(ctx, config, cs1, tmproto.PrecommitType, propBlockHash, propPartSetHeader, vs2)
+	ensurePrecommit(voteCh, height, round)
+	ensureNewBlock(newBlockCh, height)
+
+	tctx, tcancel := context.WithTimeout(ctx, ensureTimeout)
+	defer tcancel()
+	signed := make(map[string]*types.Vote)
+	for i := 0; i < 4; i++ {
+		msg, err := voteSub.Next(tctx)
+		require.NoError(t, err)
+		vote := msg.Data().(types.EventDataVote).Vote
+		signed[fmt.Sprintf("%v/%v", vote.Type, vote.ValidatorAddress)] = vote
+	}
+
+	votes, err := cs1.CommittedVotes(height)
+	require.NoError(t, err)
+	require.Len(t, votes, 4)
+	for i, vote := range votes {
+		want := signed[fmt.Sprintf("%v/%v", vote.Type, vote.ValidatorAddress)]
+		require.NotNil(t, want, "vote %d: %v", i, vote)
+		assert.Equal(t, want.ValidatorAddress, vote.ValidatorAddress)
+		assert.Equal(t, want.Timestamp, vote.Timestamp)
+		assert.Equal(t, want.Signature, vote.Signature)
+		assert.Equal(t, height, vote.Height)
+	}
+	assert.Equal(t, tmproto.PrevoteType, votes[0].Type)
+	assert.Equal(t, tmproto.PrecommitType, votes[3].Type)
+
+	_, err = cs1.CommittedVotes(height + 1)
+	require.ErrorIs(t, err, ErrHeightNotCommitted)
+	_, err = cs1.CommittedVotes(height - 1)
+	require.ErrorIs(t, err, ErrVotesNotRetained)
+}
+
+// recordingLogger records the debug messages logged through it, along with
+// the key/value pairs of both the message and the logger.
+type recordingLogger struct {
+	mtx     *sync.Mutex
+	entries *[]logEntry
+	keyVals []interface{}
+}
+
+type logEntry struct {
+	msg     string
+	keyVals map[string]interface{}
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{mtx: new(sync.Mutex), entries: new([]logEntry)}
+}
+
+func (l recordingLogger) Debug(msg string, keyVals ...interface{}) {
+	entry := logEntry{msg: msg, keyVals: make(map[string]interface{})}
+	all := append(append([]interface{}{}, l.keyVals...), keyVals...)
+	for i := 0; i+1 < len(all); i += 2 {
+		entry.keyVals[fmt.Sprint(all[i])] = all[i+1]
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	*l.entries = append(*l.entries, entry)
+}
+
+func (recordingLogger) Info(string, ...interface{})  {}
+func (recordingLogger) Error(string, ...interface{}) {}
+
+func (l recordingLogger) With(keyVals ...interface{}) log.Logger {
+	l.keyVals = append(append([]interface{}{}, l.keyVals...), keyVals...)
+	return l
+}
+
+// withKey returns the recorded entries that have key set to value.
+func (l recordingLogger) withKey(key string, value interface{}) []logEntry {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	var entries []logEntry
+	for _, entry := range *l.entries {
+		if v, ok := entry.keyVals[key]; ok && v == value {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// A node locked on a block in round 0 prevotes it again in round 1 even though
+// the proposal of round 1 is for another block, and it logs why.
+func TestStateLogsPrevoteReason(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := newRecordingLogger()
+	cs1, vss, err := randState(ctx, config, logger, 2)
+	require.NoError(t, err)
+	vs2 := vss[1]
+	height, round := cs1.Height, cs1.Round
+
+	timeoutWaitCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryTimeoutWait)
+	voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+	proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
+	newRoundCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewRound)
+
+	// Round 0: we propose, prevote our proposal and lock on it.
+	startTestRound(ctx, cs1, height, round)
+	ensureNewRound(newRoundCh, height, round)
+	ensureNewProposal(proposalCh, height, round)
+	rs := cs1.GetRoundState()
+	lockedBlockID := types.BlockID{Hash: rs.ProposalBlock.Hash(), PartSetHeader: rs.ProposalBlockParts.Header()}
+	ensurePrevote(voteCh, height, round)
+
+	entries := logger.withKey("reason", prevoteReasonFirstSeen)
+	require.Len(t, entries, 1)
+	require.Equal(t, rs.Proposal.BlockID, entries[0].keyVals["proposal_block"])
+
+	signAddVotes(ctx, config, cs1, tmproto.PrevoteType, lockedBlockID.Hash, lockedBlockID.PartSetHeader, vs2)
+	ensurePrevote(voteCh, height, round)
+	ensurePrecommit(voteCh, height, round)
+	validatePrecommit(ctx, t, cs1, round, round, vss[0], lockedBlockID.Hash, lockedBlockID.Hash)
+
+	signAddVotes(ctx, config, cs1, tmproto.PrecommitType, nil, types.PartSetHeader{}, vs2)
+	ensurePrecommit(voteCh, height, round)
+	ensureNewTimeout(timeoutWaitCh, height, round, cs1.config.Precommit(round).Nanoseconds())
+
+	// Round 1: vs2 proposes a different block, which we do not prevote.
+	cs2, _, err := randState(ctx, config, log.TestingLogger(), 2)
+	require.NoError(t, err)
+	prop, propBlock := decideProposal(ctx, cs2, vs2, vs2.Height, vs2.Round+1)
+	require.NotNil(t, prop)
+	require.NotEqual(t, lockedBlockID.Hash, prop.BlockID.Hash)
+	incrementRound(vs2)
+
+	round++
+	ensureNewRound(newRoundCh, height, round)
+	err = cs1.SetProposalAndBlock(prop, propBlock, propBlock.MakePartSet(types.BlockPartSizeBytes), "")
+	require.NoError(t, err)
+	ensureNewProposal(proposalCh, height, round)
+	ensurePrevote(voteCh, height, round)
+	validatePrevote(ctx, t, cs1, round, vss[0], lockedBlockID.Hash)
+
+	entries = logger.withKey("reason", prevoteReasonLocked)
+	require.Len(t, entries, 1)
+	require.Equal(t, round, entries[0].keyVals["round"])
+	require.Equal(t, lockedBlockID, entries[0].keyVals["locked_block"])
+	require.Equal(t, int32(0), entries[0].keyVals["locked_round"])
+	require.Equal(t, prop.BlockID, entries[0].keyVals["proposal_block"])
+	require.Equal(t, prop.POLRound, entries[0].keyVals["pol_round"])
+}
+
+// With adaptive timeouts, the timeouts follow how long the steps they bound
+// take, except for the propose step of rounds in which we propose.
+func TestStateAdaptiveTimeouts(t *testing.T) {
+	config := configSetup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs1, vss, err := randState(ctx, config, log.TestingLogger(), 4)
+	require.NoError(t, err)
+	vs2, vs3, vs4 := vss[1], vss[2], vss[3]
+	cs1.config.AdaptiveTimeouts = true
+	cs1.config.TimeoutPropose = 10 * time.Second
+	cs1.config.TimeoutPrevote = 10 * time.Second
+	cs1.config.TimeoutPrecommit = 10 * time.Second
+	height, round := cs1.Height, cs1.Round
+
+	timeouts := func() (propose, prevote, precommit time.Duration) {
+		cs1.mtx.RLock()
+		defer cs1.mtx.RUnlock()
+		return cs1.timeouts.Propose(cs1.config, 0),
+			cs1.timeouts.Prevote(cs1.config, 0),
+			cs1.timeouts.Precommit(cs1.config, 0)
+	}
+
+	voteCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryVote)
+	proposalCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryCompleteProposal)
+	newBlockHeaderCh := subscribe(ctx, t, cs1.eventBus, types.EventQueryNewBlockHeader)
+
+	// We propose at the first height, and the votes of the others are split
+	// so that we go through both wait steps.
+	startTestRound(ctx, cs1, height, round)
+	ensureNewProposal(proposalCh, height, round)
+	rs := cs1.GetRoundState()
+	blockHash, blockParts := rs.ProposalBlock.Hash(), rs.ProposalBlockParts.Header()
+	ensurePrevote(voteCh, height, round)
+
+	signAddVotes(ctx, config, cs1, tmproto.PrevoteType, nil, types.PartSetHeader{}, vs2)
+	ensurePrevote(voteCh, height, round)
+	signAddVotes(ctx, config, cs1, tmproto.PrevoteType, blockHash, blockParts, vs3, vs4)
+	ensurePrevote(voteCh, height, round)
+	ensurePrevote(voteCh, height, round)
+	ensurePrecommit(voteCh, height, round)
+
+	signAddVotes(ctx, config, cs1, tmproto.PrecommitType, nil, types.PartSetHeader{}, vs2)
+	ensurePrecommit(voteCh, height, round)
+	signAddVotes(ctx, config, cs1, tmproto.PrecommitType, blockHash, blockParts, vs3, vs4)
+	ensurePrecommit(voteCh, height, round)
+	ensurePrecommit(voteCh, height, round)
+	ensureNewBlockHeader(newBlockHeaderCh, height, blockHash)
+
+	propose, prevote, precommit := timeouts()
+	require.Equal(t, cs1.config.Propose(0), propose)
+	require.Less(t, int64(prevote), int64(cs1.config.Prevote(0)))
+	require.Less(t, int64(precommit), int64(cs1.config.Precommit(0)))
+
+	// vs2 proposes at the next height.
+	prop, propBlock := decideProposal(ctx, cs1, vs2, height+1, 0)
+	err = cs1.SetProposalAndBlock(prop, propBlock, propBlock.MakePartSet(types.BlockPartSizeBytes), "some peer")
+	require.NoError(t, err)
+	ensureNewProposal(proposalCh, height+1, 0)
+	ensurePrevote(voteCh, height+1, 0)
+
+	propose, _, _ = timeouts()
+	require.Less(t, int64(propose), int64(cs1.config.Propose(0)))
+}
+
 // subscribe subscribes test client to the given query and returns a channel with cap = 1.
 func subscribe(
 	ctx context.Context,
@@ -2116,3 +2904,23 @@ func subscribe(
 	}()
 	return ch
 }
+
+// subscribeBuffered subscribes a test client to the given query with room for
+// 20 messages, for events that follow one another too quickly for subscribe.
+func subscribeBuffered(
+	ctx context.Context,
+	t *testing.T,
+	eventBus *eventbus.EventBus,
+	q tmpubsub.Query,
+) eventbus.Subscription {
+	t.Helper()
+	sub, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+		ClientID: bufferedSubscriber,
+		Query:    q,
+		Limit:    20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to subscribe %q to %v: %v", bufferedSubscriber, q, err)
+	}
+	return sub
+}
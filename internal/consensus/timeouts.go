@@ -0,0 +1,135 @@
+package consensus
+
+import (
+	"time"
+
+	"github.com/tendermint/tendermint/config"
+	cstypes "github.com/tendermint/tendermint/internal/consensus/types"
+)
+
+const (
+	// weight of the latest step duration in the moving averages
+	adaptiveTimeoutWeight = 0.2
+	// multiple of the average step duration used as the timeout
+	adaptiveTimeoutHeadroom = 2
+	// the timeout is never shorter than the configured one divided by this
+	adaptiveTimeoutMinDivisor = 4
+)
+
+// timeoutPhase identifies the interval bounded by one of the timeouts.
+type timeoutPhase uint8
+
+const (
+	phaseNone timeoutPhase = iota
+	phasePropose
+	phasePrevote
+	phasePrecommit
+)
+
+func phaseOf(step cstypes.RoundStepType) timeoutPhase {
+	switch step {
+	case cstypes.RoundStepPropose:
+		return phasePropose
+	case cstypes.RoundStepPrevoteWait:
+		return phasePrevote
+	case cstypes.RoundStepPrecommitWait:
+		return phasePrecommit
+	default:
+		return phaseNone
+	}
+}
+
+// adaptiveTimeouts keeps an exponentially weighted moving average of the
+// durations of the propose, prevote wait and precommit wait steps, from
+// entering the step until entering the next one, and derives timeouts from
+// them. These are the intervals bounded by the propose, prevote and precommit
+// timeouts: the latter two only run once +2/3 of any votes have been
+// received, and a round in which a majority arrives at once skips the wait
+// step and is not observed. Neither is the propose step of rounds in which
+// this node proposes (see skip).
+//
+// The timeout of a step is adaptiveTimeoutHeadroom times its average,
+// clamped between 1/adaptiveTimeoutMinDivisor of the configured timeout and
+// the configured timeout, plus the configured delta for each round. A step
+// that ends because its timeout expired is observed with its full duration,
+// so the average grows back when the network slows down. Until a step has
+// been observed, its configured timeout is used.
+type adaptiveTimeouts struct {
+	averages [phasePrecommit + 1]time.Duration
+
+	phase timeoutPhase
+	since time.Time // when the current phase was entered
+}
+
+// step records that the state machine entered step at time now. Leaving the
+// propose, prevote or precommit phase updates the average of that phase.
+func (t *adaptiveTimeouts) step(step cstypes.RoundStepType, now time.Time) {
+	phase := phaseOf(step)
+	if phase == t.phase {
+		return
+	}
+	if t.phase != phaseNone {
+		t.observe(t.phase, now.Sub(t.since))
+	}
+	t.phase, t.since = phase, now
+}
+
+// skip discards the duration of the current phase, which is then not observed
+// when it ends.
+func (t *adaptiveTimeouts) skip() {
+	t.phase = phaseNone
+}
+
+func (t *adaptiveTimeouts) observe(phase timeoutPhase, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	avg := t.averages[phase]
+	if avg == 0 {
+		t.averages[phase] = d
+		return
+	}
+	t.averages[phase] = time.Duration(adaptiveTimeoutWeight*float64(d) + (1-adaptiveTimeoutWeight)*float64(avg))
+}
+
+// timeout returns the timeout of phase in round, given its configured
+// timeout and per-round delta.
+func (t *adaptiveTimeouts) timeout(phase timeoutPhase, base, delta time.Duration, round int32) time.Duration {
+	d := base
+	if avg := t.averages[phase]; avg > 0 {
+		d = adaptiveTimeoutHeadroom * avg
+		if floor := base / adaptiveTimeoutMinDivisor; d < floor {
+			d = floor
+		}
+		if d > base {
+			d = base
+		}
+	}
+	return d + delta*time.Duration(round)
+}
+
+// Propose returns the amount of time to wait for a proposal.
+func (t *adaptiveTimeouts) Propose(cfg *config.ConsensusConfig, round int32) time.Duration {
+	if !cfg.AdaptiveTimeouts {
+		return cfg.Propose(round)
+	}
+	return t.timeout(phasePropose, cfg.TimeoutPropose, cfg.TimeoutProposeDelta, round)
+}
+
+// Prevote returns the amount of time to wait for straggler votes after
+// receiving any +2/3 prevotes.
+func (t *adaptiveTimeouts) Prevote(cfg *config.ConsensusConfig, round int32) time.Duration {
+	if !cfg.AdaptiveTimeouts {
+		return cfg.Prevote(round)
+	}
+	return t.timeout(phasePrevote, cfg.TimeoutPrevote, cfg.TimeoutPrevoteDelta, round)
+}
+
+// Precommit returns the amount of time to wait for straggler votes after
+// receiving any +2/3 precommits.
+func (t *adaptiveTimeouts) Precommit(cfg *config.ConsensusConfig, round int32) time.Duration {
+	if !cfg.AdaptiveTimeouts {
+		return cfg.Precommit(round)
+	}
+	return t.timeout(phasePrecommit, cfg.TimeoutPrecommit, cfg.TimeoutPrecommitDelta, round)
+}
@@ -0,0 +1,86 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tendermint/tendermint/config"
+	cstypes "github.com/tendermint/tendermint/internal/consensus/types"
+)
+
+// runRound feeds a round whose propose, prevote wait and precommit wait steps
+// take the given durations through t, starting at now, and returns the time
+// it ends.
+func runRound(t *adaptiveTimeouts, now time.Time, propose, prevoteWait, precommitWait time.Duration) time.Time {
+	t.step(cstypes.RoundStepNewRound, now)
+	t.step(cstypes.RoundStepPropose, now)
+	now = now.Add(propose)
+	t.step(cstypes.RoundStepPrevote, now)
+	now = now.Add(time.Second) // not bounded by a timeout
+	t.step(cstypes.RoundStepPrevoteWait, now)
+	now = now.Add(prevoteWait)
+	t.step(cstypes.RoundStepPrecommit, now)
+	now = now.Add(time.Second) // not bounded by a timeout
+	t.step(cstypes.RoundStepPrecommitWait, now)
+	now = now.Add(precommitWait)
+	t.step(cstypes.RoundStepCommit, now)
+	return now
+}
+
+func TestAdaptiveTimeouts(t *testing.T) {
+	cfg := config.DefaultConsensusConfig()
+	cfg.AdaptiveTimeouts = true
+
+	var timeouts adaptiveTimeouts
+	now := time.Now()
+
+	// Nothing observed yet.
+	require.Equal(t, cfg.Propose(0), timeouts.Propose(cfg, 0))
+	require.Equal(t, cfg.Prevote(2), timeouts.Prevote(cfg, 2))
+	require.Equal(t, cfg.Precommit(0), timeouts.Precommit(cfg, 0))
+
+	// The first observation sets the average.
+	now = runRound(&timeouts, now, 1000*time.Millisecond, 400*time.Millisecond, 300*time.Millisecond)
+	require.Equal(t, 2000*time.Millisecond, timeouts.Propose(cfg, 0))
+	require.Equal(t, 800*time.Millisecond, timeouts.Prevote(cfg, 0))
+	require.Equal(t, 600*time.Millisecond, timeouts.Precommit(cfg, 0))
+	require.Equal(t, 600*time.Millisecond+2*cfg.TimeoutPrecommitDelta, timeouts.Precommit(cfg, 2))
+
+	// Faster steps shorten the timeouts, down to a quarter of the configured ones.
+	prev := timeouts.Propose(cfg, 0)
+	for i := 0; i < 50; i++ {
+		now = runRound(&timeouts, now, 100*time.Millisecond, 10*time.Millisecond, 10*time.Millisecond)
+		require.LessOrEqual(t, timeouts.Propose(cfg, 0), prev)
+		prev = timeouts.Propose(cfg, 0)
+	}
+	require.Equal(t, cfg.TimeoutPropose/adaptiveTimeoutMinDivisor, timeouts.Propose(cfg, 0))
+	require.Equal(t, cfg.TimeoutPrevote/adaptiveTimeoutMinDivisor, timeouts.Prevote(cfg, 0))
+	require.Equal(t, cfg.TimeoutPrecommit/adaptiveTimeoutMinDivisor, timeouts.Precommit(cfg, 0))
+
+	// Steps running into their timeouts lengthen them again, up to the
+	// configured ones.
+	for i := 0; i < 50; i++ {
+		now = runRound(&timeouts, now, 5*time.Second, 5*time.Second, 5*time.Second)
+		require.GreaterOrEqual(t, timeouts.Propose(cfg, 0), prev)
+		prev = timeouts.Propose(cfg, 0)
+	}
+	require.Equal(t, cfg.Propose(1), timeouts.Propose(cfg, 1))
+	require.Equal(t, cfg.Prevote(0), timeouts.Prevote(cfg, 0))
+	require.Equal(t, cfg.Precommit(0), timeouts.Precommit(cfg, 0))
+
+	// A skipped step is not observed.
+	timeouts.step(cstypes.RoundStepPropose, now)
+	timeouts.skip()
+	now = now.Add(time.Millisecond)
+	timeouts.step(cstypes.RoundStepPrevote, now)
+	require.Equal(t, cfg.Propose(0), timeouts.Propose(cfg, 0))
+
+	// Disabled, the configured timeouts are used whatever was observed.
+	runRound(&timeouts, now, time.Millisecond, time.Millisecond, time.Millisecond)
+	cfg.AdaptiveTimeouts = false
+	require.Equal(t, cfg.Propose(0), timeouts.Propose(cfg, 0))
+	require.Equal(t, cfg.Prevote(0), timeouts.Prevote(cfg, 0))
+	require.Equal(t, cfg.Precommit(0), timeouts.Precommit(cfg, 0))
+}
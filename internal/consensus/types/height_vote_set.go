@@ -3,6 +3,7 @@ package types
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -147,6 +148,32 @@ func (hvs *HeightVoteSet) Precommits(round int32) *types.VoteSet {
 	return hvs.getVoteSet(round, tmproto.PrecommitType)
 }
 
+// Votes returns copies of all the votes of the height, ordered by round, then
+// with prevotes before precommits, then by validator index.
+func (hvs *HeightVoteSet) Votes() []*types.Vote {
+	hvs.mtx.Lock()
+	defer hvs.mtx.Unlock()
+
+	rounds := make([]int32, 0, len(hvs.roundVoteSets))
+	for round := range hvs.roundVoteSets {
+		rounds = append(rounds, round)
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+
+	var votes []*types.Vote
+	for _, round := range rounds {
+		rvs := hvs.roundVoteSets[round]
+		for _, voteSet := range []*types.VoteSet{rvs.Prevotes, rvs.Precommits} {
+			for i := 0; i < voteSet.Size(); i++ {
+				if vote := voteSet.GetByIndex(int32(i)); vote != nil {
+					votes = append(votes, vote.Copy())
+				}
+			}
+		}
+	}
+	return votes
+}
+
 // Last round and blockID that has +2/3 prevotes for a particular block or nil.
 // Returns -1 if no such round exists.
 func (hvs *HeightVoteSet) POLInfo() (polRound int32, polBlockID types.BlockID) {
@@ -170,6 +170,17 @@ func (rs *RoundState) RoundStateEvent() types.EventDataRoundState {
 	}
 }
 
+// RoundStepEvent returns the H/R/S of the RoundState as an event, entered at
+// the given time.
+func (rs *RoundState) RoundStepEvent(now time.Time) types.EventDataRoundStep {
+	return types.EventDataRoundStep{
+		Height: rs.Height,
+		Round:  rs.Round,
+		Step:   rs.Step.String(),
+		Time:   now,
+	}
+}
+
 // String returns a string
 func (rs *RoundState) String() string {
 	return rs.StringIndented("")
@@ -27,18 +27,31 @@ type EventBus struct {
 	pubsub *tmpubsub.Server
 }
 
-// NewDefault returns a new event bus with default options.
-func NewDefault(l log.Logger) *EventBus {
+// NewDefault returns a new event bus with default options. Additional options
+// for the underlying pubsub server, e.g. tmpubsub.WithMetrics, are applied
+// after the defaults.
+func NewDefault(l log.Logger, options ...tmpubsub.Option) *EventBus {
 	logger := l.With("module", "eventbus")
-	pubsub := tmpubsub.NewServer(tmpubsub.BufferCapacity(0),
+	options = append([]tmpubsub.Option{tmpubsub.BufferCapacity(0),
 		func(s *tmpubsub.Server) {
 			s.Logger = logger
-		})
+		}}, options...)
+	pubsub := tmpubsub.NewServer(options...)
 	b := &EventBus{pubsub: pubsub}
 	b.BaseService = *service.NewBaseService(logger, "EventBus", b)
 	return b
 }
 
+// RetainHistory is an option for NewDefault that makes the bus retain, for
+// each event type (e.g. types.EventNewBlockValue) in limits, up to that many
+// of the most recent events of the type. A subscription created with
+// History set in its tmpubsub.SubscribeArgs receives the retained events that
+// match its query before any live ones. Events of other types are not
+// retained.
+func RetainHistory(limits map[string]int) tmpubsub.Option {
+	return tmpubsub.RetainHistory(types.EventTypeKey, limits)
+}
+
 func (b *EventBus) OnStart(ctx context.Context) error {
 	return b.pubsub.Start(ctx)
 }
@@ -51,6 +64,33 @@ func (b *EventBus) OnStop() {
 	}
 }
 
+// Close stops the event bus and waits, until ctx ends, for the delivery of
+// the messages already accepted for publication to finish. After Close
+// begins, further publishing fails with tmpubsub.ErrServerStopped. Messages
+// delivered before the bus stopped remain available to each subscriber's
+// Next, which reports tmpubsub.ErrTerminated once they are consumed.
+//
+// Close returns nil if delivery finished, or the context error if ctx ended
+// first. It is safe to call Close more than once.
+func (b *EventBus) Close(ctx context.Context) error {
+	if err := b.Stop(); err != nil && !errors.Is(err, service.ErrAlreadyStopped) {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.pubsub.Wait()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (b *EventBus) NumClients() int {
 	return b.pubsub.NumClients()
 }
@@ -182,6 +222,22 @@ func (b *EventBus) PublishEventNewRoundStep(data types.EventDataRoundState) erro
 	return b.Publish(types.EventNewRoundStepValue, data)
 }
 
+func (b *EventBus) PublishEventRoundStep(data types.EventDataRoundStep) error {
+	return b.Publish(types.EventRoundStepValue, data)
+}
+
+func (b *EventBus) PublishEventHeightSummary(data types.EventDataHeightSummary) error {
+	return b.Publish(types.EventHeightSummaryValue, data)
+}
+
+func (b *EventBus) PublishEventRoundFailure(data types.EventDataRoundFailure) error {
+	return b.Publish(types.EventRoundFailureValue, data)
+}
+
+func (b *EventBus) PublishEventTimeoutDeadline(data types.EventDataTimeoutDeadline) error {
+	return b.Publish(types.EventTimeoutDeadlineValue, data)
+}
+
 func (b *EventBus) PublishEventTimeoutPropose(data types.EventDataRoundState) error {
 	return b.Publish(types.EventTimeoutProposeValue, data)
 }
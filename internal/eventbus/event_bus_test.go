@@ -7,6 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -348,7 +351,7 @@ func TestEventBusPublish(t *testing.T) {
 	err := eventBus.Start(ctx)
 	require.NoError(t, err)
 
-	const numEventsExpected = 14
+	const numEventsExpected = 18
 
 	sub, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
 		ClientID: "test",
@@ -377,6 +380,10 @@ func TestEventBusPublish(t *testing.T) {
 	require.NoError(t, eventBus.PublishEventNewBlockHeader(types.EventDataNewBlockHeader{}))
 	require.NoError(t, eventBus.PublishEventVote(types.EventDataVote{}))
 	require.NoError(t, eventBus.PublishEventNewRoundStep(types.EventDataRoundState{}))
+	require.NoError(t, eventBus.PublishEventRoundStep(types.EventDataRoundStep{}))
+	require.NoError(t, eventBus.PublishEventTimeoutDeadline(types.EventDataTimeoutDeadline{}))
+	require.NoError(t, eventBus.PublishEventHeightSummary(types.EventDataHeightSummary{}))
+	require.NoError(t, eventBus.PublishEventRoundFailure(types.EventDataRoundFailure{}))
 	require.NoError(t, eventBus.PublishEventTimeoutPropose(types.EventDataRoundState{}))
 	require.NoError(t, eventBus.PublishEventTimeoutWait(types.EventDataRoundState{}))
 	require.NoError(t, eventBus.PublishEventNewRound(types.EventDataNewRound{}))
@@ -392,6 +399,216 @@ func TestEventBusPublish(t *testing.T) {
 	require.GreaterOrEqual(t, <-count, numEventsExpected)
 }
 
+func TestEventBusClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventBus := eventbus.NewDefault(log.TestingLogger())
+	require.NoError(t, eventBus.Start(ctx))
+
+	sub, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+		ClientID: "test",
+		Query:    tmquery.Empty{},
+		Limit:    10,
+	})
+	require.NoError(t, err)
+
+	// Hold up the delivery of the last message in the observer, so that it is
+	// still in flight when the bus is closed.
+	const numEvents = 3
+	release := make(chan struct{})
+	var observed int
+	require.NoError(t, eventBus.Observe(ctx, func(tmpubsub.Message) error {
+		if observed++; observed == numEvents {
+			<-release
+		}
+		return nil
+	}))
+	for i := 0; i < numEvents; i++ {
+		require.NoError(t, eventBus.PublishEventVote(types.EventDataVote{}))
+	}
+
+	tctx, tcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer tcancel()
+	require.ErrorIs(t, eventBus.Close(tctx), context.DeadlineExceeded)
+	require.ErrorIs(t, eventBus.PublishEventVote(types.EventDataVote{}), tmpubsub.ErrServerStopped)
+
+	close(release)
+	tctx, tcancel = context.WithTimeout(ctx, time.Second)
+	defer tcancel()
+	require.NoError(t, eventBus.Close(tctx))
+
+	// All messages accepted before the close were delivered, after which the
+	// subscription ends cleanly.
+	for i := 0; i < numEvents; i++ {
+		_, err := sub.Next(tctx)
+		require.NoError(t, err)
+	}
+	_, err = sub.Next(tctx)
+	require.ErrorIs(t, err, tmpubsub.ErrTerminated)
+}
+
+func TestEventBusHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limits := map[string]int{
+		types.EventNewRoundValue: 2,
+		types.EventPolkaValue:    2,
+	}
+	eventBus := eventbus.NewDefault(log.TestingLogger(), eventbus.RetainHistory(limits))
+	// Changing the limits afterwards does not affect the bus.
+	limits[types.EventNewRoundValue] = 0
+	delete(limits, types.EventPolkaValue)
+	require.NoError(t, eventBus.Start(ctx))
+	t.Cleanup(eventBus.Wait)
+	t.Cleanup(func() {
+		if err := eventBus.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	newRound := func(height int64) types.EventDataNewRound { return types.EventDataNewRound{Height: height} }
+	polka := func(round int32) types.EventDataRoundState { return types.EventDataRoundState{Round: round} }
+
+	require.NoError(t, eventBus.PublishEventNewRound(newRound(1)))
+	require.NoError(t, eventBus.PublishEventPolka(polka(1)))
+	require.NoError(t, eventBus.PublishEventNewRound(newRound(2)))
+	require.NoError(t, eventBus.PublishEventNewRound(newRound(3)))
+	require.NoError(t, eventBus.PublishEventPolka(polka(2)))
+	require.NoError(t, eventBus.PublishEventNewRound(newRound(4)))
+	require.NoError(t, eventBus.PublishEventPolka(polka(3)))
+	// Lock events are not retained. Since publishing is unbuffered, the events
+	// above have been delivered once this one is accepted. The Lock event
+	// itself may yet be delivered live, so it is filtered out below.
+	require.NoError(t, eventBus.PublishEventLock(types.EventDataRoundState{}))
+
+	withHistory, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+		ClientID: "test",
+		Query:    tmquery.Empty{},
+		Limit:    10,
+		Filter: func(events []abci.Event) bool {
+			return events[0].Attributes[0].Value != types.EventLockValue
+		},
+		History: true,
+	})
+	require.NoError(t, err)
+	withoutHistory, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+		ClientID: "test",
+		Query:    types.EventQueryNewRound,
+		Limit:    10,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, eventBus.PublishEventNewRound(newRound(5)))
+
+	tctx, tcancel := context.WithTimeout(ctx, time.Second)
+	defer tcancel()
+
+	// The most recent retained events arrive first, in order of publication,
+	// followed by the live ones.
+	for _, want := range []types.TMEventData{newRound(3), polka(2), newRound(4), polka(3), newRound(5)} {
+		msg, err := withHistory.Next(tctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, msg.Data())
+	}
+
+	msg, err := withoutHistory.Next(tctx)
+	require.NoError(t, err)
+	assert.Equal(t, newRound(5), msg.Data())
+}
+
+func TestEventBusSubscriptionLabels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	labelNames := []string{tmpubsub.SubscriptionLabelsKey}
+	delivered := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "delivered"}, labelNames)
+	dropped := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "dropped"}, labelNames)
+	metrics := tmpubsub.NopMetrics()
+	metrics.MessagesDelivered = prometheus.NewCounter(delivered)
+	metrics.MessagesDropped = prometheus.NewCounter(dropped)
+	eventBus := eventbus.NewDefault(log.TestingLogger(), tmpubsub.WithMetrics(metrics))
+	require.NoError(t, eventBus.Start(ctx))
+
+	_, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+		ClientID: "indexer",
+		Query:    types.EventQueryVote,
+		Limit:    2,
+		Labels:   map[string]string{"team": "infra", "purpose": "indexing"},
+	})
+	require.NoError(t, err)
+	_, err = eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+		ClientID: "unlabeled",
+		Query:    types.EventQueryVote,
+		Limit:    10,
+	})
+	require.NoError(t, err)
+
+	// Nobody reads from the subscriptions, so the third vote overflows the
+	// first one.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, eventBus.PublishEventVote(types.EventDataVote{}))
+	}
+	require.NoError(t, eventBus.Close(ctx))
+
+	const labels = "purpose=indexing,team=infra"
+	assert.Equal(t, 2.0, testutil.ToFloat64(delivered.WithLabelValues(labels)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(dropped.WithLabelValues(labels)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(delivered.WithLabelValues("")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(dropped.WithLabelValues("")))
+}
+
+func TestEventBusQueryMetrics(t *testing.T) {
+	for _, labelQueries := range []bool{true, false} {
+		labelQueries := labelQueries
+		t.Run(fmt.Sprintf("LabelQueries=%v", labelQueries), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			queryLabels := []string{tmpubsub.QueryKey}
+			evaluations := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "evaluations"}, queryLabels)
+			matches := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "matches"}, queryLabels)
+			metrics := tmpubsub.NopMetrics()
+			metrics.QueryEvaluations = prometheus.NewCounter(evaluations)
+			metrics.QueryMatches = prometheus.NewCounter(matches)
+			options := []tmpubsub.Option{tmpubsub.WithMetrics(metrics)}
+			if labelQueries {
+				options = append(options, tmpubsub.LabelQueries())
+			}
+			eventBus := eventbus.NewDefault(log.TestingLogger(), options...)
+			require.NoError(t, eventBus.Start(ctx))
+
+			for _, q := range []tmpubsub.Query{types.EventQueryVote, types.EventQueryNewBlock} {
+				_, err := eventBus.SubscribeWithArgs(ctx, tmpubsub.SubscribeArgs{
+					ClientID: "test",
+					Query:    q,
+					Limit:    10,
+				})
+				require.NoError(t, err)
+			}
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, eventBus.PublishEventVote(types.EventDataVote{}))
+			}
+			require.NoError(t, eventBus.PublishEventLock(types.EventDataRoundState{}))
+			require.NoError(t, eventBus.Close(ctx))
+
+			if !labelQueries {
+				// All queries are counted together.
+				assert.Equal(t, 8.0, testutil.ToFloat64(evaluations.WithLabelValues("")))
+				assert.Equal(t, 3.0, testutil.ToFloat64(matches.WithLabelValues("")))
+				return
+			}
+			vote, newBlock := types.EventQueryVote.String(), types.EventQueryNewBlock.String()
+			assert.Equal(t, 4.0, testutil.ToFloat64(evaluations.WithLabelValues(vote)))
+			assert.Equal(t, 3.0, testutil.ToFloat64(matches.WithLabelValues(vote)))
+			assert.Equal(t, 4.0, testutil.ToFloat64(evaluations.WithLabelValues(newBlock)))
+			assert.Equal(t, 0.0, testutil.ToFloat64(matches.WithLabelValues(newBlock)))
+		})
+	}
+}
+
 func BenchmarkEventBus(b *testing.B) {
 	benchmarks := []struct {
 		name        string
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"sort"
+
+	"github.com/tendermint/tendermint/abci/types"
+)
+
+// RetainHistory makes the server retain recent messages, for replay to
+// subscriptions that request it (see SubscribeArgs). Messages are classified
+// by the value of the event attribute with the given composite key (e.g.,
+// "tm.event"), and for each value in limits, up to that many of the most
+// recent messages are retained. Other messages are not retained.
+func RetainHistory(compositeKey string, limits map[string]int) Option {
+	// Copy the limits, which are read without locking as messages are added.
+	limitsCopy := make(map[string]int, len(limits))
+	for value, limit := range limits {
+		limitsCopy[value] = limit
+	}
+	limits = limitsCopy
+
+	return func(s *Server) {
+		s.history = &history{
+			key:      compositeKey,
+			limits:   limits,
+			retained: make(map[string][]retained, len(limits)),
+		}
+	}
+}
+
+// A retained message, numbered in order of publication.
+type retained struct {
+	seq uint64
+	msg Message
+}
+
+// A history retains recent messages, by class. It is written only by the
+// sender, with the s.subs lock held shared, and read with it held exclusive.
+type history struct {
+	key    string
+	limits map[string]int
+
+	seq      uint64
+	retained map[string][]retained // per class, oldest first
+}
+
+// add retains msg, if its class is retained, evicting the oldest message of
+// the class if the limit is reached.
+func (h *history) add(msg Message) {
+	class, ok := h.classify(msg.events)
+	if !ok {
+		return
+	}
+	h.seq++
+	buf := append(h.retained[class], retained{seq: h.seq, msg: msg})
+	if len(buf) > h.limits[class] {
+		buf = buf[len(buf)-h.limits[class]:]
+	}
+	h.retained[class] = buf
+}
+
+// classify returns the class of a message with the given events.
+func (h *history) classify(events []types.Event) (string, bool) {
+	for _, event := range events {
+		for _, attr := range event.Attributes {
+			if event.Type+"."+attr.Key != h.key {
+				continue
+			}
+			if h.limits[attr.Value] > 0 {
+				return attr.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matching returns the retained messages that match si, in order of
+// publication. If there are more than max, only the most recent are returned.
+func (h *history) matching(si *subInfo, max int) ([]Message, error) {
+	var found []retained
+	for _, buf := range h.retained {
+		for _, r := range buf {
+			match, err := si.query.Matches(r.msg.events)
+			if err != nil {
+				return nil, err
+			} else if !match {
+				continue
+			}
+			if si.filter != nil {
+				if ok, err := applyFilter(si.filter, r.msg.events); err != nil {
+					return nil, err
+				} else if !ok {
+					continue
+				}
+			}
+			found = append(found, r)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	if len(found) > max {
+		found = found[len(found)-max:]
+	}
+
+	msgs := make([]Message, len(found))
+	for i, r := range found {
+		msgs[i] = r.msg
+	}
+	return msgs, nil
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// MetricsSubsystem is a subsystem shared by all metrics exposed by this
+	// package.
+	MetricsSubsystem = "pubsub"
+
+	// SubscriptionLabelsKey is the metric label under which the labels of a
+	// subscription (see SubscribeArgs) are reported, formatted as a sorted,
+	// comma-separated list of key=value pairs.
+	SubscriptionLabelsKey = "subscription_labels"
+
+	// QueryKey is the metric label under which the query of a subscription is
+	// reported, if the server was created with LabelQueries.
+	QueryKey = "query"
+)
+
+// Metrics contains metrics exposed by this package.
+type Metrics struct {
+	// Number of messages delivered to subscriptions, by SubscriptionLabelsKey.
+	MessagesDelivered metrics.Counter
+	// Number of messages dropped because a subscription could not accept them,
+	// by SubscriptionLabelsKey.
+	MessagesDropped metrics.Counter
+
+	// Number of messages whose events were matched against the query of a
+	// subscription, by QueryKey.
+	QueryEvaluations metrics.Counter
+	// Number of messages whose events matched the query of a subscription, by
+	// QueryKey.
+	QueryMatches metrics.Counter
+	// Number of messages treated as not matching the query of a subscription
+	// because matching took longer than the limit set by MatchTimeout, by
+	// QueryKey.
+	QueryTimeouts metrics.Counter
+}
+
+// PrometheusMetrics returns Metrics build using Prometheus client library.
+// Optionally, labels can be provided along with their values ("foo",
+// "fooValue").
+func PrometheusMetrics(namespace string, labelsAndValues ...string) *Metrics {
+	labels := []string{}
+	for i := 0; i < len(labelsAndValues); i += 2 {
+		labels = append(labels, labelsAndValues[i])
+	}
+	return &Metrics{
+		MessagesDelivered: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "messages_delivered",
+			Help:      "Number of messages delivered to subscriptions.",
+		}, append(labels, SubscriptionLabelsKey)).With(labelsAndValues...),
+		MessagesDropped: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "messages_dropped",
+			Help:      "Number of messages dropped because a subscription could not accept them.",
+		}, append(labels, SubscriptionLabelsKey)).With(labelsAndValues...),
+		QueryEvaluations: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "query_evaluations",
+			Help:      "Number of messages matched against the query of a subscription.",
+		}, append(labels, QueryKey)).With(labelsAndValues...),
+		QueryMatches: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "query_matches",
+			Help:      "Number of messages that matched the query of a subscription.",
+		}, append(labels, QueryKey)).With(labelsAndValues...),
+		QueryTimeouts: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "query_timeouts",
+			Help:      "Number of messages not delivered because matching the query of a subscription timed out.",
+		}, append(labels, QueryKey)).With(labelsAndValues...),
+	}
+}
+
+// NopMetrics returns no-op Metrics.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		MessagesDelivered: discard.NewCounter(),
+		MessagesDropped:   discard.NewCounter(),
+		QueryEvaluations:  discard.NewCounter(),
+		QueryMatches:      discard.NewCounter(),
+		QueryTimeouts:     discard.NewCounter(),
+	}
+}
+
+// formatLabels renders subscription labels as the value of the
+// SubscriptionLabelsKey metric label.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -39,6 +39,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/tendermint/tendermint/abci/types"
 	"github.com/tendermint/tendermint/libs/service"
@@ -56,6 +57,9 @@ var (
 	// ErrServerStopped is returned when attempting to publish or subscribe to a
 	// server that has been stopped.
 	ErrServerStopped = errors.New("pubsub server is stopped")
+
+	// ErrFilterPanicked is reported by a subscription whose filter panicked.
+	ErrFilterPanicked = errors.New("subscription filter panicked")
 )
 
 // Query defines an interface for a query to be used for subscribing. A query
@@ -69,12 +73,44 @@ type Query interface {
 	String() string
 }
 
+// ContextQuery is a Query that can give up matching when a context ends.
+type ContextQuery interface {
+	Query
+	MatchesContext(ctx context.Context, events []types.Event) (bool, error)
+}
+
 // SubscribeArgs are the parameters to create a new subscription.
 type SubscribeArgs struct {
 	ClientID string // Client ID
 	Query    Query  // filter query for events (required)
 	Limit    int    // subscription queue capacity limit (0 means 1)
 	Quota    int    // subscription queue soft quota (0 uses Limit)
+
+	// MaxBytes, if positive, bounds the approximate total size of the
+	// messages queued for the subscription and not yet received by Next. A
+	// message that would exceed it is handled as one that exceeds Limit: the
+	// subscription is terminated. See Message.Size for how sizes are
+	// estimated.
+	MaxBytes int
+
+	// Filter, if set, is called with the events of each message matched by
+	// Query, and the message is delivered only if it reports true. Use it for
+	// conditions that cannot be expressed as a query, e.g., ones depending on
+	// external state. Filter is called from the publisher's goroutine and must
+	// not block. If Filter panics, the subscription is terminated with
+	// ErrFilterPanicked.
+	Filter func(events []types.Event) bool
+
+	// Labels, if set, are attached to the metrics reported for this
+	// subscription, e.g., to group subscriptions by owner or purpose.
+	Labels map[string]string
+
+	// History, if true, requests that the messages retained by the server
+	// (see RetainHistory) that match Query and Filter be delivered to the
+	// subscription, in order of publication, before any message published
+	// after it was created. At most Limit messages, the most recent, are
+	// replayed.
+	History bool
 }
 
 // UnsubscribeArgs are the parameters to remove a subscription.
@@ -126,6 +162,11 @@ type Server struct {
 	// TODO(creachadair): Rework the options so that this does not need to live
 	// as a field. It is not otherwise needed.
 	queueCap int
+
+	metrics      *Metrics
+	history      *history      // retained messages, if enabled
+	matchTimeout time.Duration // limit on matching a message to a query, if positive
+	labelQueries bool          // whether query metrics are labeled by query
 }
 
 // Option sets a parameter for the server.
@@ -135,7 +176,7 @@ type Option func(*Server)
 // for a detailed description of how to configure buffering. If no options are
 // provided, the resulting server's queue is unbuffered.
 func NewServer(options ...Option) *Server {
-	s := new(Server)
+	s := &Server{metrics: NopMetrics()}
 	s.BaseService = *service.NewBaseService(nil, "PubSub", s)
 	for _, opt := range options {
 		opt(s)
@@ -161,6 +202,29 @@ func BufferCapacity(cap int) Option {
 	return func(s *Server) { s.queueCap = cap }
 }
 
+// MatchTimeout limits the time spent matching the events of a message against
+// the query of a subscription, for queries that support it by implementing
+// ContextQuery. If matching takes longer than d, the message is treated as
+// not matching, and the timeout is logged and counted in the QueryTimeouts
+// metric. By default, there is no limit.
+func MatchTimeout(d time.Duration) Option {
+	return func(s *Server) { s.matchTimeout = d }
+}
+
+// LabelQueries reports the QueryEvaluations, QueryMatches and QueryTimeouts
+// metrics separately for each query, under the QueryKey label. As every
+// distinct query a client subscribes with then adds series that are never
+// removed, this is only meant for servers whose subscribers are trusted. By
+// default, the label is empty and the metrics count all queries together.
+func LabelQueries() Option {
+	return func(s *Server) { s.labelQueries = true }
+}
+
+// WithMetrics sets the metrics reported by the server.
+func WithMetrics(metrics *Metrics) Option {
+	return func(s *Server) { s.metrics = metrics }
+}
+
 // BufferCapacity returns capacity of the publication queue.
 func (s *Server) BufferCapacity() int { return cap(s.queue) }
 
@@ -244,16 +308,42 @@ func (s *Server) SubscribeWithArgs(ctx context.Context, args SubscribeArgs) (*Su
 	if args.Limit == 0 {
 		args.Limit = 1
 	}
-	sub, err := newSubscription(args.Quota, args.Limit)
+	if args.MaxBytes < 0 {
+		return nil, errors.New("negative byte limit")
+	}
+	sub, err := newSubscription(args.Quota, args.Limit, args.MaxBytes)
 	if err != nil {
 		return nil, err
 	}
-	s.subs.index.add(&subInfo{
+	si := &subInfo{
 		clientID: args.ClientID,
 		query:    args.Query,
+		filter:   args.Filter,
+		labels:   formatLabels(args.Labels),
 		subID:    sub.id,
 		sub:      sub,
-	})
+	}
+
+	// Replay the history before the subscription is indexed. Holding the
+	// exclusive lock ensures that no message is published in between, so
+	// each message is either replayed or delivered, but not both.
+	if args.History && s.history != nil {
+		msgs, err := s.history.matching(si, args.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("replaying history: %w", err)
+		}
+		for _, msg := range msgs {
+			msg.subID = sub.id
+			if sub.maxBytes > 0 {
+				msg.size = msg.Size()
+			}
+			if err := sub.publish(msg); err != nil {
+				return nil, fmt.Errorf("replaying history: %w", err)
+			}
+		}
+	}
+
+	s.subs.index.add(si)
 	return sub, nil
 }
 
@@ -350,6 +440,15 @@ func (s *Server) publish(ctx context.Context, data interface{}, events []types.E
 	s.pubs.RLock()
 	defer s.pubs.RUnlock()
 
+	// Check for a stopped server first: if the queue has room, the select
+	// below picks at random between it and done, so publish would otherwise
+	// only sometimes report ErrServerStopped after a stop.
+	select {
+	case <-s.done:
+		return ErrServerStopped
+	default:
+	}
+
 	select {
 	case <-s.done:
 		return ErrServerStopped
@@ -410,13 +509,15 @@ func (s *Server) removeSubs(evict subInfoSet, reason error) {
 // send delivers the given message to all matching subscribers.  An error in
 // query matching stops transmission and is returned.
 func (s *Server) send(data interface{}, events []types.Event) error {
-	// At exit, evict any subscriptions that were too slow.
-	evict := make(subInfoSet)
+	// At exit, evict any subscriptions that were too slow or whose filter
+	// panicked.
+	evict, failed := make(subInfoSet), make(subInfoSet)
 	defer func() {
-		if len(evict) != 0 {
+		if len(evict) != 0 || len(failed) != 0 {
 			s.subs.Lock()
 			defer s.subs.Unlock()
 			s.removeSubs(evict, ErrTerminated)
+			s.removeSubs(failed, ErrFilterPanicked)
 		}
 	}()
 
@@ -438,27 +539,84 @@ func (s *Server) send(data interface{}, events []types.Event) error {
 			return fmt.Errorf("observer failed on message: %w", err)
 		}
 	}
+	if s.history != nil {
+		s.history.add(Message{data: data, events: events})
+	}
 
+	msg := Message{data: data, events: events}
 	for si := range s.subs.index.all {
-		match, err := si.query.Matches(events)
-		if err != nil {
+		queryLabel := ""
+		if s.labelQueries {
+			queryLabel = si.query.String()
+		}
+
+		match, err := s.match(si.query, events)
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.Logger.Error("Query evaluation timed out; not delivering message",
+				"subscriber", si.clientID, "query", si.query.String(), "timeout", s.matchTimeout)
+			s.metrics.QueryEvaluations.With(QueryKey, queryLabel).Add(1)
+			s.metrics.QueryTimeouts.With(QueryKey, queryLabel).Add(1)
+			continue
+		} else if err != nil {
 			return fmt.Errorf("match failed against query: %w", err)
 			// TODO(creachadair): Should we evict this subscription?
-		} else if !match {
+		}
+		s.metrics.QueryEvaluations.With(QueryKey, queryLabel).Add(1)
+		if !match {
 			continue
 		}
+		s.metrics.QueryMatches.With(QueryKey, queryLabel).Add(1)
+
+		// The query matched; apply the subscriber's filter, if any.
+		if si.filter != nil {
+			ok, err := applyFilter(si.filter, events)
+			if err != nil {
+				s.Logger.Error("Subscription filter failed",
+					"subscriber", si.clientID, "query", si.query.String(), "err", err)
+				failed.add(si)
+				continue
+			} else if !ok {
+				continue
+			}
+		}
 
 		// Publish the events to the subscriber's queue. If this fails, e.g.,
-		// because the queue is over capacity or out of quota, evict the
-		// subscription from the index.
-		if err := si.sub.publish(Message{
-			subID:  si.sub.id,
-			data:   data,
-			events: events,
-		}); err != nil {
+		// because the queue is over capacity, out of quota or over its byte
+		// limit, evict the subscription from the index.
+		msg.subID = si.sub.id
+		if si.sub.maxBytes > 0 && msg.size == 0 {
+			msg.size = msg.Size()
+		}
+		if err := si.sub.publish(msg); err != nil {
 			evict.add(si)
+			s.metrics.MessagesDropped.With(SubscriptionLabelsKey, si.labels).Add(1)
+		} else {
+			s.metrics.MessagesDelivered.With(SubscriptionLabelsKey, si.labels).Add(1)
 		}
 	}
 
 	return nil
 }
+
+// match matches events against q, within the time limit set by MatchTimeout,
+// if any.
+func (s *Server) match(q Query, events []types.Event) (bool, error) {
+	cq, ok := q.(ContextQuery)
+	if !ok || s.matchTimeout <= 0 {
+		return q.Matches(events)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.matchTimeout)
+	defer cancel()
+	return cq.MatchesContext(ctx, events)
+}
+
+// applyFilter reports whether filter accepts events. If filter panics, the
+// panic is recovered and reported as an error.
+func applyFilter(filter func([]types.Event) bool, events []types.Event) (ok bool, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("%w: %v", ErrFilterPanicked, x)
+		}
+	}()
+	return filter(events), nil
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	abci "github.com/tendermint/tendermint/abci/types"
 	"github.com/tendermint/tendermint/libs/log"
@@ -147,6 +151,117 @@ func TestSlowSubscriber(t *testing.T) {
 	sub.mustFail(ctx, pubsub.ErrTerminated)
 }
 
+func TestSubscribeFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newTestServer(ctx, t)
+
+	// The filter admits only transfers to addresses in this set, which it
+	// consults at delivery time.
+	allowed := map[string]bool{"addr1": true}
+	sub := newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID,
+		Query:    query.MustParse("transfer.recipient EXISTS"),
+		Filter: func(events []abci.Event) bool {
+			for _, event := range events {
+				for _, attr := range event.Attributes {
+					if attr.Key == "recipient" && allowed[attr.Value] {
+						return true
+					}
+				}
+			}
+			return false
+		},
+		Limit: 10,
+	}))
+	transfer := func(recipient string) []abci.Event {
+		return []abci.Event{{
+			Type:       "transfer",
+			Attributes: []abci.EventAttribute{{Key: "recipient", Value: recipient}},
+		}}
+	}
+
+	// The query matches but the filter rejects.
+	require.NoError(t, s.PublishWithEvents(ctx, "Nova", transfer("addr2")))
+	// The query does not match, so the filter is not consulted.
+	require.NoError(t, s.PublishWithEvents(ctx, "Quasar", []abci.Event{{
+		Type:       "mint",
+		Attributes: []abci.EventAttribute{{Key: "recipient", Value: "addr1"}},
+	}}))
+	// Both match.
+	require.NoError(t, s.PublishWithEvents(ctx, "Gladiator", transfer("addr1")))
+	sub.mustReceive(ctx, "Gladiator")
+	sub.mustTimeOut(ctx, 100*time.Millisecond)
+
+	t.Run("Panic", func(t *testing.T) {
+		bad := newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+			ClientID: clientID + "-panic",
+			Query:    query.Empty{},
+			Filter:   func([]abci.Event) bool { panic("boom") },
+		}))
+
+		require.NoError(t, s.PublishWithEvents(ctx, "Ronan", transfer("addr1")))
+		bad.mustFail(ctx, pubsub.ErrFilterPanicked)
+
+		// Other subscribers are not affected.
+		sub.mustReceive(ctx, "Ronan")
+	})
+}
+
+func TestSubscribeMaxBytes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newTestServer(ctx, t)
+
+	_, err := s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID,
+		Query:    query.Empty{},
+		MaxBytes: -1,
+	})
+	require.Error(t, err)
+
+	sub := newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID,
+		Query:    query.Empty{},
+		Limit:    10,
+		MaxBytes: 100,
+	}))
+	large := strings.Repeat("x", 60)
+
+	// Receiving a message releases its share of the budget.
+	require.NoError(t, s.Publish(ctx, large))
+	sub.mustReceive(ctx, large)
+	require.NoError(t, s.Publish(ctx, large))
+	sub.mustReceive(ctx, large)
+
+	// Two queued messages exceed the budget, although they are well within
+	// the message limit.
+	require.NoError(t, s.Publish(ctx, large))
+	require.NoError(t, s.Publish(ctx, large))
+	sub.mustReceive(ctx, large)
+	sub.mustFail(ctx, pubsub.ErrTerminated)
+
+	// Event attributes count toward the size.
+	sub = newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID + "-events",
+		Query:    query.Empty{},
+		Limit:    10,
+		MaxBytes: 100,
+	}))
+	require.NoError(t, s.PublishWithEvents(ctx, "Gamora", []abci.Event{{
+		Type:       "tx",
+		Attributes: []abci.EventAttribute{{Key: "memo", Value: large}},
+	}}))
+	require.NoError(t, s.PublishWithEvents(ctx, "Drax", []abci.Event{{
+		Type:       "tx",
+		Attributes: []abci.EventAttribute{{Key: "memo", Value: large}},
+	}}))
+	sub.mustReceive(ctx, "Gamora")
+	sub.mustFail(ctx, pubsub.ErrTerminated)
+}
+
 func TestDifferentClients(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -381,6 +496,76 @@ func TestUnsubscribeAll(t *testing.T) {
 
 }
 
+// slowQuery matches all messages, but when matching with a context, it takes
+// until the context ends.
+type slowQuery struct{}
+
+func (slowQuery) Matches([]abci.Event) (bool, error) { return true, nil }
+func (slowQuery) String() string                     { return "slow" }
+
+func (slowQuery) MatchesContext(ctx context.Context, _ []abci.Event) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestMatchTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timeouts := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "timeouts"}, []string{pubsub.QueryKey})
+	metrics := pubsub.NopMetrics()
+	metrics.QueryTimeouts = prometheus.NewCounter(timeouts)
+	s := pubsub.NewServer(pubsub.MatchTimeout(10*time.Millisecond), pubsub.WithMetrics(metrics), pubsub.LabelQueries(),
+		func(s *pubsub.Server) {
+			s.Logger = log.TestingLogger()
+		})
+	require.NoError(t, s.Start(ctx))
+	t.Cleanup(s.Wait)
+
+	slow := newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID,
+		Query:    slowQuery{},
+	}))
+	fast := newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID,
+		Query:    query.Empty{},
+	}))
+
+	require.NoError(t, s.Publish(ctx, "Quicksilver"))
+	fast.mustReceive(ctx, "Quicksilver")
+	slow.mustTimeOut(ctx, 50*time.Millisecond)
+	require.Equal(t, 1.0, testutil.ToFloat64(timeouts.WithLabelValues("slow")))
+}
+
+func TestMatchTimeoutQuery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A limit of a nanosecond has passed before matching is done, so a query
+	// that would match never does.
+	timeouts := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "timeouts"}, []string{pubsub.QueryKey})
+	metrics := pubsub.NopMetrics()
+	metrics.QueryTimeouts = prometheus.NewCounter(timeouts)
+	s := pubsub.NewServer(pubsub.MatchTimeout(time.Nanosecond), pubsub.WithMetrics(metrics),
+		func(s *pubsub.Server) {
+			s.Logger = log.TestingLogger()
+		})
+	require.NoError(t, s.Start(ctx))
+	t.Cleanup(s.Wait)
+
+	sub := newTestSub(t).must(s.SubscribeWithArgs(ctx, pubsub.SubscribeArgs{
+		ClientID: clientID,
+		Query:    query.MustParse("transfer.recipient = 'addr1'"),
+	}))
+
+	require.NoError(t, s.PublishWithEvents(ctx, "Quicksilver", []abci.Event{{
+		Type:       "transfer",
+		Attributes: []abci.EventAttribute{{Key: "recipient", Value: "addr1"}},
+	}}))
+	sub.mustTimeOut(ctx, 50*time.Millisecond)
+	require.Equal(t, 1.0, testutil.ToFloat64(timeouts.WithLabelValues("")))
+}
+
 func TestBufferCapacity(t *testing.T) {
 	s := pubsub.NewServer(pubsub.BufferCapacity(2),
 		func(s *pubsub.Server) {
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tendermint/tendermint/abci/types"
+)
+
+// A Signature describes an expected event: its type and the attributes an
+// event of that type must have.
+type Signature struct {
+	Type       string
+	Attributes []string
+}
+
+// DeviationKind tells how an event deviates from a baseline.
+type DeviationKind uint8
+
+const (
+	// DeviationNewType is an event whose type is not in the baseline.
+	DeviationNewType DeviationKind = iota + 1
+	// DeviationMissingAttributes is an event of a known type that lacks some
+	// of the attributes expected for that type.
+	DeviationMissingAttributes
+)
+
+// String returns a human readable name for the kind.
+func (k DeviationKind) String() string {
+	switch k {
+	case DeviationNewType:
+		return "new type"
+	case DeviationMissingAttributes:
+		return "missing attributes"
+	default:
+		return "unknown"
+	}
+}
+
+// A Deviation is an event of a batch that does not conform to the baseline.
+type Deviation struct {
+	Index   int // position of the event in the batch
+	Type    string
+	Kind    DeviationKind
+	Missing []string // expected attributes the event lacks, in baseline order
+}
+
+// String returns a human readable description of the deviation.
+func (d Deviation) String() string {
+	if d.Kind == DeviationMissingAttributes {
+		return fmt.Sprintf("event %d (%s): %s %v", d.Index, d.Type, d.Kind, d.Missing)
+	}
+	return fmt.Sprintf("event %d (%s): %s", d.Index, d.Type, d.Kind)
+}
+
+// A BaselineChecker reports the events of a batch that deviate from a
+// previously captured set of event signatures. Each expected attribute is
+// compiled into an EXISTS query, and the queries of a type are evaluated
+// together as a CompiledSet.
+type BaselineChecker struct {
+	attrs map[string][]string
+	sets  map[string]*CompiledSet
+}
+
+// NewBaselineChecker compiles baseline into a checker. Signatures with the
+// same type are merged. It returns an error if a type is empty or an
+// attribute cannot be expressed as a query tag.
+func NewBaselineChecker(baseline []Signature) (*BaselineChecker, error) {
+	c := &BaselineChecker{
+		attrs: make(map[string][]string),
+		sets:  make(map[string]*CompiledSet),
+	}
+	seen := make(map[string]struct{})
+	for _, sig := range baseline {
+		if sig.Type == "" {
+			return nil, errors.New("baseline signature has an empty type")
+		}
+		if _, ok := c.attrs[sig.Type]; !ok {
+			c.attrs[sig.Type] = []string{}
+		}
+		for _, attr := range sig.Attributes {
+			key := sig.Type + "." + attr
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			c.attrs[sig.Type] = append(c.attrs[sig.Type], attr)
+		}
+	}
+
+	for typ, attrs := range c.attrs {
+		queries := make([]string, len(attrs))
+		for i, attr := range attrs {
+			queries[i] = fmt.Sprintf("%s.%s EXISTS", typ, attr)
+		}
+		set, err := CompileSet(queries)
+		if err != nil {
+			return nil, fmt.Errorf("baseline signature for %q: %w", typ, err)
+		}
+		c.sets[typ] = set
+	}
+	return c, nil
+}
+
+// Check returns the deviations of events from the baseline, in the order of
+// the events, or nil if all of them conform. Events with an empty type are
+// ignored, as they are when matching queries.
+func (c *BaselineChecker) Check(events []types.Event) []Deviation {
+	var deviations []Deviation
+	for i, event := range events {
+		if event.Type == "" {
+			continue
+		}
+		set, ok := c.sets[event.Type]
+		if !ok {
+			deviations = append(deviations, Deviation{Index: i, Type: event.Type, Kind: DeviationNewType})
+			continue
+		}
+
+		var missing []string
+		for j, match := range set.Match([]types.Event{event}) {
+			if !match {
+				missing = append(missing, c.attrs[event.Type][j])
+			}
+		}
+		if len(missing) > 0 {
+			deviations = append(deviations, Deviation{
+				Index:   i,
+				Type:    event.Type,
+				Kind:    DeviationMissingAttributes,
+				Missing: missing,
+			})
+		}
+	}
+	return deviations
+}
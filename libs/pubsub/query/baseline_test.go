@@ -0,0 +1,79 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/pubsub/query"
+)
+
+func newEvent(typ string, keys ...string) abci.Event {
+	event := abci.Event{Type: typ}
+	for _, key := range keys {
+		event.Attributes = append(event.Attributes, abci.EventAttribute{Key: key, Value: "v"})
+	}
+	return event
+}
+
+func TestBaselineChecker(t *testing.T) {
+	checker, err := query.NewBaselineChecker([]query.Signature{
+		{Type: "transfer", Attributes: []string{"sender", "recipient"}},
+		{Type: "transfer", Attributes: []string{"amount", "sender"}},
+		{Type: "message", Attributes: []string{"action"}},
+		{Type: "tm"},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		events []abci.Event
+		want   []query.Deviation
+	}{
+		{"empty batch", nil, nil},
+		{
+			"conforming",
+			[]abci.Event{
+				newEvent("transfer", "sender", "recipient", "amount", "memo"),
+				newEvent("message", "action"),
+				newEvent("tm", "event"),
+				newEvent(""),
+			},
+			nil,
+		},
+		{
+			"new type",
+			[]abci.Event{
+				newEvent("message", "action"),
+				newEvent("withdraw", "validator"),
+			},
+			[]query.Deviation{{Index: 1, Type: "withdraw", Kind: query.DeviationNewType}},
+		},
+		{
+			"missing attributes",
+			[]abci.Event{
+				newEvent("transfer", "sender", "recipient", "amount"),
+				newEvent("transfer", "recipient"),
+				newEvent("message"),
+			},
+			[]query.Deviation{
+				{Index: 1, Type: "transfer", Kind: query.DeviationMissingAttributes, Missing: []string{"sender", "amount"}},
+				{Index: 2, Type: "message", Kind: query.DeviationMissingAttributes, Missing: []string{"action"}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, checker.Check(tc.events))
+		})
+	}
+}
+
+func TestBaselineCheckerInvalid(t *testing.T) {
+	_, err := query.NewBaselineChecker([]query.Signature{{Attributes: []string{"sender"}}})
+	require.Error(t, err)
+
+	_, err = query.NewBaselineChecker([]query.Signature{{Type: "transfer", Attributes: []string{"bad key"}}})
+	require.Error(t, err)
+}
@@ -4,6 +4,15 @@ import (
 	"github.com/tendermint/tendermint/abci/types"
 )
 
+var (
+	// All is a query that matches any set of events, including an empty one,
+	// unlike a Query, which never matches an empty set of events.
+	All = Empty{}
+
+	// None is a query that matches no set of events.
+	None = Never{}
+)
+
 // Empty query matches any set of events.
 type Empty struct {
 }
@@ -16,3 +25,16 @@ func (Empty) Matches(events []types.Event) (bool, error) {
 func (Empty) String() string {
 	return "empty"
 }
+
+// Never query matches no set of events.
+type Never struct {
+}
+
+// Matches always returns false.
+func (Never) Matches(events []types.Event) (bool, error) {
+	return false, nil
+}
+
+func (Never) String() string {
+	return "never"
+}
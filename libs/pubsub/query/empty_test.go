@@ -53,3 +53,31 @@ func TestEmptyQueryMatchesAnything(t *testing.T) {
 		require.True(t, match)
 	}
 }
+
+func TestAllAndNone(t *testing.T) {
+	batches := [][]abci.Event{
+		nil,
+		{},
+		{{Type: "tm", Attributes: []abci.EventAttribute{{Key: "event", Value: "NewBlock"}}}},
+	}
+
+	for _, events := range batches {
+		match, err := query.All.Matches(events)
+		require.NoError(t, err)
+		require.True(t, match, "events %v", events)
+
+		match, err = query.None.Matches(events)
+		require.NoError(t, err)
+		require.False(t, match, "events %v", events)
+	}
+
+	// Unlike All, a parsed query never matches an empty set of events.
+	q := query.MustParse("tm.event EXISTS")
+	for _, events := range batches[:2] {
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.False(t, match)
+	}
+
+	require.NotEqual(t, query.All.String(), query.None.String())
+}
@@ -9,11 +9,17 @@
 package query
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tendermint/tendermint/abci/types"
@@ -21,12 +27,126 @@ import (
 
 var (
 	numRegex = regexp.MustCompile(`([0-9\.]+)`)
+
+	// ErrTooManyConditions is reported by New for a query with more
+	// conditions than permitted (see MaxConditions).
+	ErrTooManyConditions = errors.New("too many conditions in query")
 )
 
+// DefaultMaxConditions is the number of conditions a query may have unless
+// set otherwise with MaxConditions.
+const DefaultMaxConditions = 1000
+
 // Query holds the query string and the query parser.
 type Query struct {
 	str    string
 	parser *QueryParser
+
+	// Operands bound by NewWithArgs, keyed by their offset in the parser buffer.
+	bound map[int]interface{}
+
+	missing       MissingPolicy
+	timePrecision bool
+	exclude       map[string]struct{} // event types ignored by matching
+	cache         *resultCache
+	comments      bool
+	trimSpace     bool
+	maxConditions int
+	defaultType   string
+
+	// If all conditions refer to events of one type, that type, else "".
+	eventType string
+
+	// Declared types of attribute values, by composite key.
+	schema map[string]ValueType
+
+	// Symbols stripped from numeric values (see NumericSymbols).
+	numericSymbols []string
+
+	// Value of missing attributes in string equality conditions, if
+	// missingStrings is set.
+	missingStrings bool
+	missingString  string
+
+	// Aliases of enumeration values registered by Enum, keyed by composite key
+	// and then by name or code.
+	enums map[string]map[string]map[string]struct{}
+}
+
+// Option sets an optional parameter on the Query.
+type Option func(*Query)
+
+// MissingPolicy determines how a numeric comparison treats an event attribute
+// that is not present in the events.
+type MissingPolicy uint8
+
+const (
+	// MissingNoMatch makes the condition fail (default).
+	MissingNoMatch MissingPolicy = iota
+	// MissingAsZero compares the operand against 0 instead, e.g.
+	// "fee.amount < 10" matches events without a fee.amount attribute.
+	MissingAsZero
+)
+
+// MissingNumbers sets the policy for numeric comparisons against missing
+// event attributes. It has no effect on string, time and EXISTS conditions.
+func MissingNumbers(policy MissingPolicy) Option {
+	return func(q *Query) { q.missing = policy }
+}
+
+// MissingStrings makes "=" conditions with a string operand compare a missing
+// event attribute as if its value were def, e.g. with an empty def, a query
+// for an empty tx.memo matches events without a tx.memo attribute. Without
+// this option, such a condition never matches a missing attribute. It has no
+// effect on other operators and operand types.
+func MissingStrings(def string) Option {
+	return func(q *Query) {
+		q.missingStrings = true
+		q.missingString = def
+	}
+}
+
+// ValueType is the declared type of the values of an event attribute.
+type ValueType uint8
+
+const (
+	// ValueInt values are decimal integers, e.g. "-42".
+	ValueInt ValueType = iota + 1
+	// ValueFloat values are decimal floating-point numbers, e.g. "4.2".
+	ValueFloat
+	// ValueTime values are times or dates, in the layouts of TIME and DATE
+	// operands.
+	ValueTime
+	// ValueBool values are booleans, e.g. "true" or "false", and are compared
+	// for equality with string operands, e.g. "tx.success = 'true'".
+	ValueBool
+)
+
+// String returns the name of the value type.
+func (t ValueType) String() string {
+	switch t {
+	case ValueInt:
+		return "integer"
+	case ValueFloat:
+		return "float"
+	case ValueTime:
+		return "time"
+	case ValueBool:
+		return "boolean"
+	default:
+		return "unknown"
+	}
+}
+
+// Schema declares the types of the values of event attributes, by composite
+// key. Values of declared attributes are parsed strictly as the declared type
+// when compared to operands of a matching type: an integer value must consist
+// of an optional sign and decimal digits only, for example, where otherwise
+// the first number found in the value is used, so that "100stake" compares as
+// 100. A value that does not parse makes matching fail with an error. Other
+// attributes and operand types are matched as usual.
+func Schema(schema map[string]ValueType) Option {
+	return func(q *Query) { q.schema = schema }
 }
 
 // Condition represents a single condition within a query and consists of composite key
@@ -37,15 +157,475 @@ type Condition struct {
 	Operand      interface{}
 }
 
+// String returns the condition in the syntax of a query, with the operand in
+// a normal form, e.g., "tx.gas > 7", "tx.fee = 1.0" or
+// "tx.time = TIME 2013-05-03T14:45:00Z". Conditions that are equal have the
+// same string; in particular, times are shown in UTC, whatever the zone of
+// the operand. A set bound by NewWithArgs is shown as an opaque literal that
+// identifies its elements.
+func (c Condition) String() string {
+	var operand string
+	switch v := c.Operand.(type) {
+	case nil:
+		return c.CompositeKey + " " + c.Op.String()
+	case string:
+		operand = "'" + v + "'"
+	case int64:
+		operand = strconv.FormatInt(v, 10)
+	case float64:
+		operand = strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.ContainsAny(operand, ".") {
+			operand += ".0"
+		}
+	case time.Time:
+		operand = "TIME " + v.UTC().Format(time.RFC3339Nano)
+	case map[string]struct{}:
+		operand = setLiteral(v)
+	default:
+		operand = fmt.Sprint(v)
+	}
+	return c.CompositeKey + " " + c.Op.String() + " " + operand
+}
+
+// Diff compares the conditions of queries a and b, in the form reported by
+// Condition.String, and returns those of b that a does not have (added) and
+// those of a that b does not have (removed), each in query order. A condition
+// that is modified shows as one removed and one added. Conditions repeated
+// within a query are counted separately.
+func Diff(a, b *Query) (added, removed []string, err error) {
+	aConds, err := a.conditions()
+	if err != nil {
+		return nil, nil, err
+	}
+	bConds, err := b.conditions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count := make(map[string]int, len(aConds))
+	for _, cond := range aConds {
+		count[cond.String()]++
+	}
+	for _, cond := range bConds {
+		if str := cond.String(); count[str] > 0 {
+			count[str]--
+		} else {
+			added = append(added, str)
+		}
+	}
+	for _, cond := range aConds {
+		if str := cond.String(); count[str] > 0 {
+			count[str]--
+			removed = append(removed, str)
+		}
+	}
+	return added, removed, nil
+}
+
+// ExcludeTypes makes the query ignore events of the given types: their
+// attributes are disregarded when matching, as though the events were absent.
+// This also applies to EXISTS conditions on a bare name, e.g., with "tx"
+// excluded, "tx EXISTS" is not satisfied by an event of type "tx", but it is
+// by one of type "txs" as usual.
+func ExcludeTypes(types []string) Option {
+	return func(q *Query) {
+		if q.exclude == nil {
+			q.exclude = make(map[string]struct{}, len(types))
+		}
+		for _, t := range types {
+			q.exclude[t] = struct{}{}
+		}
+	}
+}
+
+// TrimSpace makes string conditions ("=" and CONTAINS with a quoted operand,
+// or "=" with a set bound by NewWithArgs) ignore leading and trailing
+// whitespace in both the operand and the event values, e.g., "tx.memo='hi'"
+// matches the value " hi\n". The trimmed values are then compared as usual,
+// e.g., as declared by Schema or Enum. Numeric and time conditions are
+// unaffected.
+func TrimSpace() Option {
+	return func(q *Query) { q.trimSpace = true }
+}
+
+// NumericSymbols strips a leading or trailing occurrence of one of symbols,
+// e.g. "%" or "$", from event values before they are compared to a numeric
+// operand, so that "swap.rate > 10" matches "12.5%" and "tx.fee = 1.5"
+// matches "$1.50". This matters for attributes declared as ValueInt or
+// ValueFloat by Schema, whose values otherwise fail to parse. By default, no
+// symbols are stripped.
+func NumericSymbols(symbols ...string) Option {
+	return func(q *Query) { q.numericSymbols = symbols }
+}
+
+// AllowComments makes New accept comments in the query string, to annotate
+// queries kept in configuration files. A comment starts with "#" or "//"
+// outside of a quoted value, and extends to the end of the line. Moreover,
+// runs of whitespace outside of quoted values, including newlines, are
+// treated as single spaces, so that queries may span several lines:
+//
+//	tm.event = 'Tx'         # transactions
+//	AND tx.height > 1000    // after the upgrade
+//	AND tx.memo = '#1'      # not a comment within quotes
+//
+// String reports the query without comments and with whitespace collapsed,
+// i.e., queries differing only in comments and layout are reported the same.
+func AllowComments() Option {
+	return func(q *Query) { q.comments = true }
+}
+
+// CacheResults makes Matches remember its results for up to size distinct
+// batches of events, identified by a SHA-256 digest of their contents.
+// This pays off when the same batches are matched repeatedly, e.g. when
+// re-querying historical blocks; otherwise it only adds the cost of computing
+// the digest. Results that are errors are not cached.
+func CacheResults(size int) Option {
+	return func(q *Query) {
+		q.cache = &resultCache{size: size, results: make(map[[sha256.Size]byte]bool)}
+	}
+}
+
+// MatchTimePrecision makes "=" conditions on times compare at the precision
+// of the operand, i.e., the event value is truncated to the smallest power of
+// ten fraction of a second in which the operand is expressed, down to a
+// nanosecond. For example, "tx.time = TIME 2021-01-01T10:00:05Z" matches the
+// value "2021-01-01T10:00:05.123456789Z". Without this option, times must be
+// equal to the nanosecond.
+//
+// TIME literals in the query text have a precision of one second. A time bound
+// by NewWithArgs may be more precise: one 120ms past the second requires the
+// value to agree to the hundredth of a second.
+func MatchTimePrecision() Option {
+	return func(q *Query) { q.timePrecision = true }
+}
+
+// Enum registers an enumeration whose values an application may emit either
+// by name or by numeric code, under the given composite key. "=" conditions on
+// that key then match both forms, whichever the operand is: for example, with
+//
+//	Enum("account.status", map[string]int{"ACTIVE": 1, "FROZEN": 2})
+//
+// "account.status = 'ACTIVE'" and "account.status = 1" both match the values
+// "ACTIVE" and "1". Operands that are not values of the enumeration, and other
+// operators, are unaffected.
+func Enum(compositeKey string, codes map[string]int) Option {
+	return func(q *Query) {
+		if q.enums == nil {
+			q.enums = make(map[string]map[string]map[string]struct{})
+		}
+		aliases := q.enums[compositeKey]
+		if aliases == nil {
+			aliases = make(map[string]map[string]struct{}, 2*len(codes))
+			q.enums[compositeKey] = aliases
+		}
+		for name, code := range codes {
+			set := map[string]struct{}{name: {}, strconv.Itoa(code): {}}
+			aliases[name] = set
+			aliases[strconv.Itoa(code)] = set
+		}
+	}
+}
+
+// DefaultType makes tags without a type, i.e. without a ".", refer to
+// attributes of events of the given type: with DefaultType("tx"), the query
+// "height > 5 AND sender EXISTS" is equivalent to "tx.height > 5 AND
+// tx.sender EXISTS". Tags with a type are unaffected. Note that this changes
+// the meaning of EXISTS on a bare name, which otherwise matches any attribute
+// whose composite key starts with the name.
+func DefaultType(eventType string) Option {
+	return func(q *Query) { q.defaultType = eventType }
+}
+
+// MaxConditions sets the number of conditions a query may have; New rejects a
+// query with more, reporting ErrTooManyConditions. The default is
+// DefaultMaxConditions. A value of zero or less removes the limit.
+func MaxConditions(n int) Option {
+	return func(q *Query) { q.maxConditions = n }
+}
+
 // New parses the given string and returns a query or error if the string is
 // invalid.
-func New(s string) (*Query, error) {
-	p := &QueryParser{Buffer: fmt.Sprintf(`"%s"`, s)}
+func New(s string, options ...Option) (*Query, error) {
+	q := &Query{str: s, maxConditions: DefaultMaxConditions}
+	for _, option := range options {
+		option(q)
+	}
+	if q.comments {
+		q.str = stripComments(s)
+	}
+
+	p := &QueryParser{Buffer: fmt.Sprintf(`"%s"`, q.str)}
 	p.Init()
 	if err := p.Parse(); err != nil {
 		return nil, err
 	}
-	return &Query{str: s, parser: p}, nil
+	if q.maxConditions > 0 {
+		if n := countConditions(p); n > q.maxConditions {
+			return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyConditions, n, q.maxConditions)
+		}
+	}
+	q.parser = p
+	q.eventType = singleEventType(p, q.defaultType)
+	return q, nil
+}
+
+// singleEventType returns the event type to which all the conditions parsed by
+// p refer, if there is one. Conditions on keys without a type or with a type
+// prefix ("prefix*.key") refer to no single type.
+func singleEventType(p *QueryParser, defaultType string) string {
+	var (
+		eventType string
+		single    = true
+	)
+	begin, end := 0, 0
+	// N.B. Tokens must be drained, or the goroutine producing them leaks.
+	for token := range p.Tokens() {
+		switch token.pegRule {
+		case rulePegText:
+			begin, end = int(token.begin), int(token.end)
+
+		case ruletag:
+			tag := p.Buffer[begin:end]
+			if defaultType != "" && !strings.Contains(tag, ".") {
+				tag = defaultType + "." + tag
+			}
+			i := strings.Index(tag, ".")
+			if i < 0 || strings.Contains(tag, "*") || (eventType != "" && tag[:i] != eventType) {
+				single = false
+				continue
+			}
+			eventType = tag[:i]
+		}
+	}
+	if !single {
+		return ""
+	}
+	return eventType
+}
+
+// countConditions returns the number of conditions in the query parsed by p.
+func countConditions(p *QueryParser) int {
+	var n int
+	for token := range p.Tokens() {
+		if token.pegRule == rulecondition {
+			n++
+		}
+	}
+	return n
+}
+
+// stripComments removes comments from s and collapses whitespace outside of
+// quoted values, as described for AllowComments.
+func stripComments(s string) string {
+	var (
+		buf    strings.Builder
+		quoted bool
+		space  bool // whitespace is pending
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '#' || c == '/' && i+1 < len(s) && s[i+1] == '/':
+			for i+1 < len(s) && s[i+1] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		}
+
+		if space && buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		space = false
+		buf.WriteByte(c)
+	}
+
+	return buf.String()
+}
+
+// NewWithArgs parses the given string as New does, after binding the
+// positional placeholders $1, $2, ... to the corresponding args. A bound
+// argument is used verbatim as the operand of its condition, so string values
+// need no quoting or escaping, which makes it safe to construct queries from
+// untrusted input:
+//
+//	q, err := query.NewWithArgs("tx.sender = $1 AND tx.height > $2", sender, 100)
+//
+// Each argument must be a string, an int, an int64, a float64, a time.Time or
+// a map[string]struct{}, and must be referenced by at least one placeholder. A
+// placeholder may only appear where its argument type is allowed by the
+// grammar, e.g., a string cannot be the operand of "<". Placeholders within
+// quoted values are not substituted.
+//
+// A map[string]struct{} argument denotes a set of strings, and may only be the
+// operand of "=". The condition matches if any value of the attribute is in
+// the set, which allows large allowlists to be matched without spelling them
+// out in the query:
+//
+//	q, err := query.NewWithArgs("tx.sender = $1", allowlist)
+//
+// Arguments of type Option are not bound to placeholders, and are not
+// counted when numbering them; they are applied to the query as by New.
+//
+// String reports the query with the arguments filled in. When a string
+// argument contains quotes, that result is informative but cannot be parsed.
+func NewWithArgs(s string, args ...interface{}) (*Query, error) {
+	var options []Option
+	for i := 0; i < len(args); {
+		if option, ok := args[i].(Option); ok {
+			options = append(options, option)
+			args = append(args[:i:i], args[i+1:]...)
+			continue
+		}
+		i++
+	}
+
+	// Comments must be removed before placeholders are located, as they may
+	// contain dollar signs of their own.
+	var probe Query
+	for _, option := range options {
+		option(&probe)
+	}
+	if probe.comments {
+		s = stripComments(s)
+	}
+
+	var (
+		text   strings.Builder // what the parser sees
+		shown  strings.Builder // what String reports
+		bound  = make(map[int]interface{})
+		used   = make([]bool, len(args))
+		quoted bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\'' {
+			quoted = !quoted
+		}
+		if s[i] != '$' || quoted {
+			text.WriteByte(s[i])
+			shown.WriteByte(s[i])
+			continue
+		}
+
+		j := i + 1
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(s[i+1 : j])
+		if err != nil || n < 1 || n > len(args) {
+			return nil, fmt.Errorf("invalid placeholder %q for %d arguments", s[i:j], len(args))
+		}
+
+		operand, err := bindArg(args[n-1])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %w", s[i:j], err)
+		}
+		used[n-1] = true
+
+		// The parser buffer is wrapped in a pair of double quotes (see New),
+		// which shifts every offset by one.
+		bound[text.Len()+1+operand.offset] = operand.value
+		text.WriteString(operand.text)
+		shown.WriteString(operand.shown)
+		i = j - 1
+	}
+
+	for i, ok := range used {
+		if !ok {
+			return nil, fmt.Errorf("argument $%d is not used", i+1)
+		}
+	}
+
+	q, err := New(text.String(), options...)
+	if err != nil {
+		return nil, err
+	}
+	q.str = shown.String()
+	q.bound = bound
+
+	conditions, err := q.conditions()
+	if err != nil {
+		return nil, err
+	}
+	for _, cond := range conditions {
+		if _, ok := cond.Operand.(map[string]struct{}); ok && cond.Op != OpEqual {
+			return nil, fmt.Errorf("set argument cannot be the operand of %s", cond.Op)
+		}
+	}
+	return q, nil
+}
+
+// A boundArg describes a query argument supplied to NewWithArgs.
+type boundArg struct {
+	text   string      // placeholder literal satisfying the grammar
+	offset int         // offset of the operand within text
+	shown  string      // literal reported by String
+	value  interface{} // operand used for matching
+}
+
+// bindArg returns the bound form of the given query argument, or an error if
+// its type is not supported.
+func bindArg(arg interface{}) (boundArg, error) {
+	switch v := arg.(type) {
+	case string:
+		return boundArg{text: "''", shown: "'" + v + "'", value: v}, nil
+	case int:
+		return boundArg{text: "0", shown: strconv.Itoa(v), value: int64(v)}, nil
+	case int64:
+		return boundArg{text: "0", shown: strconv.FormatInt(v, 10), value: v}, nil
+	case float64:
+		return boundArg{text: "1.0", shown: strconv.FormatFloat(v, 'f', -1, 64), value: v}, nil
+	case time.Time:
+		return boundArg{
+			text:   "TIME 2006-01-02T15:04:05Z",
+			offset: len("TIME "),
+			shown:  "TIME " + v.Format(TimeLayout),
+			value:  v,
+		}, nil
+	case map[string]struct{}:
+		return boundArg{text: "''", shown: setLiteral(v), value: v}, nil
+	default:
+		return boundArg{}, fmt.Errorf("unsupported type %T", arg)
+	}
+}
+
+// setLiteral returns a compact rendering of a set argument for String. It
+// includes a digest of the elements, so that queries bound to distinct sets
+// are reported as distinct strings.
+func setLiteral(set map[string]struct{}) string {
+	elts := make([]string, 0, len(set))
+	for elt := range set {
+		elts = append(elts, elt)
+	}
+	sort.Strings(elts)
+
+	h := sha256.New()
+	for _, elt := range elts {
+		h.Write([]byte(elt))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("@set(%d:%x)", len(elts), h.Sum(nil)[:8])
+}
+
+// Validate reports whether s is a valid query. It returns the error New would
+// report for s, or an error for an operand that is well-formed but cannot be
+// represented, such as an integer that overflows int64, which New accepts but
+// which would make every call to Matches fail.
+func Validate(s string) error {
+	q, err := New(s)
+	if err != nil {
+		return err
+	}
+	_, err = q.conditions()
+	return err
 }
 
 // MustParse turns the given string into a query or panics; for tests or others
@@ -84,6 +664,28 @@ const (
 	OpExists
 )
 
+// String returns the query syntax for op.
+func (op Operator) String() string {
+	switch op {
+	case OpLessEqual:
+		return "<="
+	case OpGreaterEqual:
+		return ">="
+	case OpLess:
+		return "<"
+	case OpGreater:
+		return ">"
+	case OpEqual:
+		return "="
+	case OpContains:
+		return "CONTAINS"
+	case OpExists:
+		return "EXISTS"
+	default:
+		return fmt.Sprintf("Operator(%d)", uint8(op))
+	}
+}
+
 const (
 	// DateLayout defines a layout for all dates (`DATE date`)
 	DateLayout = "2006-01-02"
@@ -93,7 +695,28 @@ const (
 
 // Conditions returns a list of conditions. It returns an error if there is any
 // error with the provided grammar in the Query.
+//
+// The conditions are a copy, which callers may modify without affecting q: in
+// particular, a set bound by NewWithArgs is copied.
 func (q *Query) Conditions() ([]Condition, error) {
+	conditions, err := q.conditions()
+	if err != nil {
+		return nil, err
+	}
+	for i, cond := range conditions {
+		if set, ok := cond.Operand.(map[string]struct{}); ok {
+			clone := make(map[string]struct{}, len(set))
+			for elt := range set {
+				clone[elt] = struct{}{}
+			}
+			conditions[i].Operand = clone
+		}
+	}
+	return conditions, nil
+}
+
+// conditions implements Conditions, without copying bound sets.
+func (q *Query) conditions() ([]Condition, error) {
 	var (
 		eventAttr string
 		op        Operator
@@ -104,12 +727,20 @@ func (q *Query) Conditions() ([]Condition, error) {
 
 	// tokens must be in the following order: tag ("tx.gas") -> operator ("=") -> operand ("7")
 	for token := range q.parser.Tokens() {
+		if value, ok := q.bound[begin]; ok && isOperand(token.pegRule) {
+			conditions = append(conditions, Condition{eventAttr, op, value})
+			continue
+		}
+
 		switch token.pegRule {
 		case rulePegText:
 			begin, end = int(token.begin), int(token.end)
 
 		case ruletag:
 			eventAttr = buffer[begin:end]
+			if q.defaultType != "" && !strings.Contains(eventAttr, ".") {
+				eventAttr = q.defaultType + "." + eventAttr
+			}
 
 		case rulele:
 			op = OpLessEqual
@@ -193,6 +824,16 @@ func (q *Query) Conditions() ([]Condition, error) {
 	return conditions, nil
 }
 
+// isOperand reports whether rule denotes the operand of a condition.
+func isOperand(rule pegRule) bool {
+	switch rule {
+	case rulevalue, rulenumber, ruletime, ruledate:
+		return true
+	default:
+		return false
+	}
+}
+
 // Matches returns true if the query matches against any event in the given set
 // of events, false otherwise. For each event, a match exists if the query is
 // matched against *any* value in a slice of values. An error is returned if
@@ -200,187 +841,427 @@ func (q *Query) Conditions() ([]Condition, error) {
 //
 // For example, query "name=John" matches events = {"name": ["John", "Eric"]}.
 // More examples could be found in parser_test.go and query_test.go.
+//
+// A condition on "prefix*.key" applies to the values of key in all events
+// whose type starts with prefix, e.g., "ibc*.channel='channel-0'" matches if
+// any event of type "ibc_transfer", "ibc_client", etc. has that channel.
 func (q *Query) Matches(rawEvents []types.Event) (bool, error) {
+	return q.MatchesContext(context.Background(), rawEvents)
+}
+
+// MatchesContext is like Matches, but gives up with the error of ctx if ctx
+// ends before matching is done. The context is checked before and after each
+// condition is evaluated, so a match that completes after ctx ends is reported
+// as that error too.
+func (q *Query) MatchesContext(ctx context.Context, rawEvents []types.Event) (bool, error) {
 	if len(rawEvents) == 0 {
 		return false, nil
 	}
 
-	events := flattenEvents(rawEvents)
-
-	var (
-		eventAttr string
-		op        Operator
-	)
-
-	buffer, begin, end := q.parser.Buffer, 0, 0
-
-	// tokens must be in the following order:
+	if q.cache == nil {
+		return q.matches(ctx, rawEvents)
+	}
 
-	// tag ("tx.gas") -> operator ("=") -> operand ("7")
-	for token := range q.parser.Tokens() {
-		switch token.pegRule {
-		case rulePegText:
-			begin, end = int(token.begin), int(token.end)
+	key := fingerprint(rawEvents)
+	if match, ok := q.cache.get(key); ok {
+		return match, nil
+	}
+	match, err := q.matches(ctx, rawEvents)
+	if err != nil {
+		return false, err
+	}
+	q.cache.put(key, match)
+	return match, nil
+}
 
-		case ruletag:
-			eventAttr = buffer[begin:end]
+// matches implements Matches for a non-empty set of events, without consulting
+// the result cache.
+func (q *Query) matches(ctx context.Context, rawEvents []types.Event) (bool, error) {
+	conditions, err := q.conditions()
+	if err != nil {
+		return false, err
+	}
 
-		case rulele:
-			op = OpLessEqual
+	events := q.flatten(rawEvents)
+	memo := make(valueMemo)
+	for _, cond := range conditions {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		match, err := q.matchCondition(cond, events, memo)
+		if err != nil {
+			return false, err
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
 
-		case rulege:
-			op = OpGreaterEqual
+		if !match {
+			return false, nil
+		}
+	}
 
-		case rulel:
-			op = OpLess
+	return true, nil
+}
 
-		case ruleg:
-			op = OpGreater
+// MatchesFlat reports whether the query matches a map from composite keys
+// ("type.attribute") to values, such as one obtained by merging the events of
+// a batch. Matches flattens its events into the same form, so conditions are
+// never bound to a single event in either case: "a.x=1 AND a.y=2" is satisfied
+// by one event with both attributes or by two events with one each. What a
+// merged map loses is only which values came from which batch, so a query can
+// match a merged map even though it matches none of the batches on their own.
+//
+// Keys whose type, taken to be the part before the first ".", is excluded by
+// ExcludeTypes are ignored. Results are not cached (see CacheResults). An
+// empty map matches no query.
+func (q *Query) MatchesFlat(kv map[string][]string) (bool, error) {
+	if len(kv) == 0 {
+		return false, nil
+	}
 
-		case ruleequal:
-			op = OpEqual
+	conditions, err := q.conditions()
+	if err != nil {
+		return false, err
+	}
 
-		case rulecontains:
-			op = OpContains
-		case ruleexists:
-			op = OpExists
-			if strings.Contains(eventAttr, ".") {
-				// Searching for a full "type.attribute" event.
-				_, ok := events[eventAttr]
-				if !ok {
-					return false, nil
-				}
-			} else {
-				foundEvent := false
-
-			loop:
-				for compositeKey := range events {
-					if strings.Index(compositeKey, eventAttr) == 0 {
-						foundEvent = true
-						break loop
-					}
-				}
-				if !foundEvent {
-					return false, nil
+	events := kv
+	if len(q.exclude) != 0 {
+		events = make(map[string][]string, len(kv))
+		for key, values := range kv {
+			if i := strings.Index(key, "."); i >= 0 {
+				if _, ok := q.exclude[key[:i]]; ok {
+					continue
 				}
 			}
+			events[key] = values
+		}
+	}
 
-		case rulevalue:
-			// strip single quotes from value (i.e. "'NewBlock'" -> "NewBlock")
-			valueWithoutSingleQuotes := buffer[begin+1 : end-1]
+	memo := make(valueMemo)
+	for _, cond := range conditions {
+		match, err := q.matchCondition(cond, events, memo)
+		if err != nil {
+			return false, err
+		}
 
-			// see if the triplet (event attribute, operator, operand) matches any event
-			// "tx.gas", "=", "7", { "tx.gas": 7, "tx.ID": "4AE393495334" }
-			match, err := match(eventAttr, op, reflect.ValueOf(valueWithoutSingleQuotes), events)
-			if err != nil {
-				return false, err
-			}
+		if !match {
+			return false, nil
+		}
+	}
 
-			if !match {
-				return false, nil
-			}
+	return true, nil
+}
 
-		case rulenumber:
-			number := buffer[begin:end]
-			if strings.ContainsAny(number, ".") { // if it looks like a floating-point number
-				value, err := strconv.ParseFloat(number, 64)
-				if err != nil {
-					err = fmt.Errorf(
-						"got %v while trying to parse %s as float64 (should never happen if the grammar is correct)",
-						err, number,
-					)
-					return false, err
-				}
+// Explain reports whether the query matches the given events, as Matches does.
+// When it does not, Explain also reports the index (into Conditions) of the
+// first condition that failed, and a human-readable reason for the failure.
+// This is meant to help debug subscriptions that do not fire as expected.
+//
+// If the query matches, failedIndex is -1 and failedReason is empty. If the
+// query fails for a reason not attributable to a single condition (e.g., there
+// are no events at all), failedIndex is -1 and failedReason is non-empty.
+func (q *Query) Explain(rawEvents []types.Event) (matched bool, failedIndex int, failedReason string) {
+	if len(rawEvents) == 0 {
+		return false, -1, "no events"
+	}
 
-				match, err := match(eventAttr, op, reflect.ValueOf(value), events)
-				if err != nil {
-					return false, err
-				}
+	conditions, err := q.conditions()
+	if err != nil {
+		return false, -1, err.Error()
+	}
 
-				if !match {
-					return false, nil
-				}
-			} else {
-				value, err := strconv.ParseInt(number, 10, 64)
-				if err != nil {
-					err = fmt.Errorf(
-						"got %v while trying to parse %s as int64 (should never happen if the grammar is correct)",
-						err, number,
-					)
-					return false, err
-				}
+	events := q.flatten(rawEvents)
+	memo := make(valueMemo)
+	for i, cond := range conditions {
+		match, err := q.matchCondition(cond, events, memo)
+		if err != nil {
+			return false, i, err.Error()
+		}
 
-				match, err := match(eventAttr, op, reflect.ValueOf(value), events)
-				if err != nil {
-					return false, err
-				}
+		if !match {
+			return false, i, explainFailure(cond, events)
+		}
+	}
 
-				if !match {
-					return false, nil
-				}
-			}
+	return true, -1, ""
+}
 
-		case ruletime:
-			value, err := time.Parse(TimeLayout, buffer[begin:end])
-			if err != nil {
-				err = fmt.Errorf(
-					"got %v while trying to parse %s as time.Time / RFC3339 (should never happen if the grammar is correct)",
-					err, buffer[begin:end],
-				)
-				return false, err
-			}
+// MatchingEventIndices reports, for each condition of the query (in the order
+// of Conditions), the positions of the events that satisfy it on their own.
+// A condition that fails to match an event with an error, e.g. because a value
+// cannot be parsed as a number, is treated as not satisfied by that event.
+//
+// Note that Matches considers the attributes of all the events together, so a
+// query may match even if no single event satisfies all its conditions.
+func (q *Query) MatchingEventIndices(rawEvents []types.Event) [][]int {
+	conditions, err := q.conditions()
+	if err != nil {
+		return nil
+	}
 
-			match, err := match(eventAttr, op, reflect.ValueOf(value), events)
-			if err != nil {
-				return false, err
-			}
+	events := make([]map[string][]string, len(rawEvents))
+	for i, event := range rawEvents {
+		events[i] = q.flatten([]types.Event{event})
+	}
 
-			if !match {
-				return false, nil
+	indices := make([][]int, len(conditions))
+	memo := make(valueMemo)
+	for i, cond := range conditions {
+		indices[i] = []int{}
+		for j := range events {
+			if match, err := q.matchCondition(cond, events[j], memo); err == nil && match {
+				indices[i] = append(indices[i], j)
 			}
+		}
+	}
 
-		case ruledate:
-			value, err := time.Parse("2006-01-02", buffer[begin:end])
-			if err != nil {
-				err = fmt.Errorf(
-					"got %v while trying to parse %s as time.Time / '2006-01-02' (should never happen if the grammar is correct)",
-					err, buffer[begin:end],
-				)
-				return false, err
-			}
+	return indices
+}
 
-			match, err := match(eventAttr, op, reflect.ValueOf(value), events)
-			if err != nil {
-				return false, err
-			}
+// TagStats maps composite keys (e.g. "tx.sender") to their cardinality, i.e.,
+// the number of distinct values observed for them, for use by Selectivity.
+type TagStats map[string]int
 
-			if !match {
-				return false, nil
+// Estimated fractions of events satisfying a condition, by kind of condition,
+// used by Selectivity. Equality on a key with known cardinality n is instead
+// estimated as 1/n.
+const (
+	selectEqual    = 0.1 // equality on a key of unknown cardinality
+	selectRange    = 0.3 // <, <=, >, >=
+	selectContains = 0.5
+	selectExists   = 0.9
+)
+
+// Selectivity returns the indices (into Conditions) of the query's conditions,
+// ordered from the most to the least selective given the cardinality
+// statistics in stats. Evaluating conditions in this order lets a caller
+// discard non-matching events after as few checks as possible.
+//
+// The estimate is a heuristic: equality on a key with n distinct values is
+// assumed to pass 1/n of the events, and the other operators a fixed fraction,
+// with CONTAINS and EXISTS being the broadest. Conditions with the same
+// estimate keep their relative order.
+func (q *Query) Selectivity(stats TagStats) []int {
+	conditions, err := q.conditions()
+	if err != nil {
+		return nil
+	}
+
+	estimates := make([]float64, len(conditions))
+	order := make([]int, len(conditions))
+	for i, cond := range conditions {
+		order[i] = i
+		switch cond.Op {
+		case OpEqual:
+			estimates[i] = selectEqual
+			if n := stats[cond.CompositeKey]; n > 0 {
+				estimates[i] = 1 / float64(n)
 			}
+		case OpContains:
+			estimates[i] = selectContains
+		case OpExists:
+			estimates[i] = selectExists
+		default:
+			estimates[i] = selectRange
 		}
 	}
 
-	return true, nil
+	sort.SliceStable(order, func(i, j int) bool {
+		return estimates[order[i]] < estimates[order[j]]
+	})
+	return order
 }
 
-// match returns true if the given triplet (attribute, operator, operand) matches
-// any value in an event for that attribute. If any match fails with an error,
-// that error is returned.
-//
-// First, it looks up the key in the events and if it finds one, tries to compare
-// all the values from it to the operand using the operator.
-//
-// "tx.gas", "=", "7", {"tx": [{"gas": 7, "ID": "4AE393495334"}]}
-func match(attr string, op Operator, operand reflect.Value, events map[string][]string) (bool, error) {
-	// look up the tag from the query in tags
-	values, ok := events[attr]
+// explainFailure describes why cond did not match the given events.
+func explainFailure(cond Condition, events map[string][]string) string {
+	if cond.Op == OpExists {
+		return fmt.Sprintf("no event attribute matching %q exists", cond.CompositeKey)
+	}
+
+	values, ok := lookup(cond.CompositeKey, events)
 	if !ok {
+		return fmt.Sprintf("event attribute %q not found", cond.CompositeKey)
+	}
+
+	operand := cond.Operand
+	if set, ok := operand.(map[string]struct{}); ok {
+		operand = setLiteral(set)
+	}
+	return fmt.Sprintf("no value of %q satisfies %s %v (values: %q)", cond.CompositeKey, cond.Op, operand, values)
+}
+
+// matchCondition returns true if the given condition matches the events. If
+// the match fails with an error, that error is returned.
+func (q *Query) matchCondition(cond Condition, events map[string][]string, memo valueMemo) (bool, error) {
+	if cond.Op == OpExists {
+		return exists(cond.CompositeKey, events), nil
+	}
+
+	if t, ok := cond.Operand.(time.Time); ok && cond.Op == OpEqual && q.timePrecision {
+		values, _ := lookup(cond.CompositeKey, events)
+		return matchTimeEqual(values, t, memo)
+	}
+
+	if s, ok := cond.Operand.(string); ok && q.trimSpace {
+		cond.Operand = strings.TrimSpace(s)
+	}
+
+	operand := reflect.ValueOf(cond.Operand)
+	if set, ok := q.enumAliases(cond); ok {
+		operand = reflect.ValueOf(set)
+	}
+
+	values, found := lookup(cond.CompositeKey, events)
+	if !found {
+		switch {
+		case q.missingStrings && cond.Op == OpEqual && operand.Kind() == reflect.String:
+			return matchValue(q.missingString, cond.Op, operand, memo)
+		case q.missing == MissingAsZero && (operand.Kind() == reflect.Int64 || operand.Kind() == reflect.Float64):
+			return matchValue("0", cond.Op, operand, memo)
+		}
 		return false, nil
 	}
 
+	// Normalize the values as configured, then compare them as usual.
+	switch operand.Kind() {
+	case reflect.String, reflect.Map:
+		if q.trimSpace {
+			values = mapValues(values, strings.TrimSpace)
+		}
+	case reflect.Int64, reflect.Float64:
+		if len(q.numericSymbols) != 0 {
+			values = mapValues(values, func(value string) string { return trimSymbols(value, q.numericSymbols) })
+		}
+	}
+
+	if typ, ok := q.schema[cond.CompositeKey]; ok {
+		if match, ok, err := matchTyped(cond, typ, operand, values); ok {
+			return match, err
+		}
+	}
+
+	// see if the triplet (event attribute, operator, operand) matches any event
+	// "tx.gas", "=", "7", { "tx.gas": 7, "tx.ID": "4AE393495334" }
+	return matchValues(values, cond.Op, operand, memo)
+}
+
+// mapValues returns the results of applying f to each of values.
+func mapValues(values []string, f func(string) string) []string {
+	mapped := make([]string, len(values))
+	for i, value := range values {
+		mapped[i] = f(value)
+	}
+	return mapped
+}
+
+// enumAliases returns the names and codes equivalent to the operand of cond,
+// if it is an "=" condition on an enumeration registered by Enum.
+func (q *Query) enumAliases(cond Condition) (map[string]struct{}, bool) {
+	aliases, ok := q.enums[cond.CompositeKey]
+	if !ok || cond.Op != OpEqual {
+		return nil, false
+	}
+
+	var key string
+	switch operand := cond.Operand.(type) {
+	case string:
+		key = operand
+	case int64:
+		key = strconv.FormatInt(operand, 10)
+	default:
+		return nil, false
+	}
+	set, ok := aliases[key]
+	return set, ok
+}
+
+// matchTimeEqual returns true if any of the given values equals t, when
+// truncated to the precision of t (see MatchTimePrecision).
+func matchTimeEqual(values []string, t time.Time, memo valueMemo) (bool, error) {
+	precision := time.Second
+	for t.Nanosecond()%int(precision) != 0 {
+		precision /= 10
+	}
+
+	for _, value := range values {
+		v, err := memo.time(value)
+		if err != nil {
+			return false, err
+		}
+
+		if v.Truncate(precision).Equal(t) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// exists returns true if the given attribute is present in the events. If attr
+// has the form "type.attribute" it must be present verbatim, or for the family
+// of types "prefix*.attribute", in an event whose type has that prefix;
+// otherwise it is treated as a prefix of the composite keys.
+func exists(attr string, events map[string][]string) bool {
+	if strings.Contains(attr, ".") {
+		// Searching for a full "type.attribute" event.
+		_, ok := lookup(attr, events)
+		return ok
+	}
+
+	attr = strings.TrimSuffix(attr, "*")
+	for compositeKey := range events {
+		if strings.HasPrefix(compositeKey, attr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns the values of the given attribute in the events, and whether
+// it is present.
+//
+// An attribute of the form "prefix*.key" denotes the attribute key of every
+// event whose type starts with prefix, e.g., "ibc*.channel" denotes both
+// "ibc_transfer.channel" and "ibc_client.channel". Its values are those of all
+// such events, ordered by type. The type of such an event is taken to end at
+// the first "." after prefix, so "ibc*.channel" does not denote the attribute
+// "memo.channel" of an event of type "ibc_transfer".
+func lookup(attr string, events map[string][]string) ([]string, bool) {
+	i := strings.Index(attr, "*.")
+	if i < 0 {
+		values, ok := events[attr]
+		return values, ok
+	}
+
+	prefix, key := attr[:i], attr[i+2:]
+	var keys []string
+	for compositeKey := range events {
+		if !strings.HasPrefix(compositeKey, prefix) {
+			continue
+		}
+		rest := compositeKey[len(prefix):]
+		if j := strings.Index(rest, "."); j >= 0 && rest[j+1:] == key {
+			keys = append(keys, compositeKey)
+		}
+	}
+	sort.Strings(keys)
+
+	var values []string
+	for _, key := range keys {
+		values = append(values, events[key]...)
+	}
+	return values, len(keys) != 0
+}
+
+// matchValues returns true if any of the given values matches the operand
+// using the operator. If any match fails with an error, that error is
+// returned.
+func matchValues(values []string, op Operator, operand reflect.Value, memo valueMemo) (bool, error) {
 	for _, value := range values {
 		// return true if any value in the set of the event's values matches
-		match, err := matchValue(value, op, operand)
+		match, err := matchValue(value, op, operand, memo)
 		if err != nil {
 			return false, err
 		}
@@ -396,122 +1277,376 @@ func match(attr string, op Operator, operand reflect.Value, events map[string][]
 // matchValue will attempt to match a string value against an operator an
 // operand. A boolean is returned representing the match result. It will return
 // an error if the value cannot be parsed and matched against the operand type.
-func matchValue(value string, op Operator, operand reflect.Value) (bool, error) {
+// Values parsed as times or numbers are remembered in memo, if it is not nil.
+func matchValue(value string, op Operator, operand reflect.Value, memo valueMemo) (bool, error) {
 	switch operand.Kind() {
 	case reflect.Struct: // time
 		operandAsTime := operand.Interface().(time.Time)
 
-		// try our best to convert value from events to time.Time
-		var (
-			v   time.Time
-			err error
-		)
+		v, err := memo.time(value)
+		if err != nil {
+			return false, err
+		}
 
-		if strings.ContainsAny(value, "T") {
-			v, err = time.Parse(TimeLayout, value)
-		} else {
-			v, err = time.Parse(DateLayout, value)
+		return compareTimes(op, v, operandAsTime), nil
+
+	case reflect.Float64:
+		operandFloat64 := operand.Interface().(float64)
+
+		v, err := memo.float(value)
+		if err != nil {
+			return false, err
 		}
+
+		return compareFloats(op, v, operandFloat64), nil
+
+	case reflect.Int64:
+		operandInt := operand.Interface().(int64)
+
+		v, err := memo.int(value)
 		if err != nil {
-			return false, fmt.Errorf("failed to convert value %v from event attribute to time.Time: %w", value, err)
+			return false, err
 		}
 
+		return compareInts(op, v, operandInt), nil
+
+	case reflect.String:
 		switch op {
-		case OpLessEqual:
-			return (v.Before(operandAsTime) || v.Equal(operandAsTime)), nil
-		case OpGreaterEqual:
-			return (v.Equal(operandAsTime) || v.After(operandAsTime)), nil
-		case OpLess:
-			return v.Before(operandAsTime), nil
-		case OpGreater:
-			return v.After(operandAsTime), nil
 		case OpEqual:
-			return v.Equal(operandAsTime), nil
+			return value == operand.String(), nil
+		case OpContains:
+			return strings.Contains(value, operand.String()), nil
 		}
 
-	case reflect.Float64:
-		var v float64
+	case reflect.Map: // set of strings bound by NewWithArgs
+		if op == OpEqual {
+			_, ok := operand.Interface().(map[string]struct{})[value]
+			return ok, nil
+		}
 
-		operandFloat64 := operand.Interface().(float64)
-		filteredValue := numRegex.FindString(value)
+	default:
+		return false, fmt.Errorf("unknown kind of operand %v", operand.Kind())
+	}
 
-		// try our best to convert value from tags to float64
-		v, err := strconv.ParseFloat(filteredValue, 64)
+	return false, nil
+}
+
+// matchTyped matches cond against the values of its attribute, parsed strictly
+// as the declared type typ. It reports ok false, and does not match, if the
+// operand cannot be compared to values of that type.
+func matchTyped(
+	cond Condition, typ ValueType, operand reflect.Value, values []string,
+) (match, ok bool, err error) {
+	var compare func(value string) (bool, error)
+
+	switch {
+	case (typ == ValueInt || typ == ValueFloat) && operand.Kind() == reflect.Int64:
+		operandInt := operand.Int()
+		compare = func(value string) (bool, error) {
+			if typ == ValueFloat {
+				v, err := strconv.ParseFloat(value, 64)
+				return compareFloats(cond.Op, v, float64(operandInt)), err
+			}
+			v, err := strconv.ParseInt(value, 10, 64)
+			return compareInts(cond.Op, v, operandInt), err
+		}
+
+	case (typ == ValueInt || typ == ValueFloat) && operand.Kind() == reflect.Float64:
+		operandFloat64 := operand.Float()
+		compare = func(value string) (bool, error) {
+			if typ == ValueInt {
+				v, err := strconv.ParseInt(value, 10, 64)
+				return compareFloats(cond.Op, float64(v), operandFloat64), err
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			return compareFloats(cond.Op, v, operandFloat64), err
+		}
+
+	case typ == ValueTime && operand.Kind() == reflect.Struct:
+		operandAsTime := operand.Interface().(time.Time)
+		compare = func(value string) (bool, error) {
+			v, err := parseTime(value)
+			return compareTimes(cond.Op, v, operandAsTime), err
+		}
+
+	case typ == ValueBool && operand.Kind() == reflect.String && cond.Op == OpEqual:
+		operandBool, err := strconv.ParseBool(operand.String())
 		if err != nil {
-			return false, fmt.Errorf("failed to convert value %v from event attribute to float64: %w", filteredValue, err)
+			return false, true, fmt.Errorf("operand %q of %s is not a valid %s: %w",
+				operand.String(), cond.CompositeKey, typ, err)
+		}
+		compare = func(value string) (bool, error) {
+			v, err := strconv.ParseBool(value)
+			return v == operandBool, err
 		}
 
-		switch op {
-		case OpLessEqual:
-			return v <= operandFloat64, nil
-		case OpGreaterEqual:
-			return v >= operandFloat64, nil
-		case OpLess:
-			return v < operandFloat64, nil
-		case OpGreater:
-			return v > operandFloat64, nil
-		case OpEqual:
-			return v == operandFloat64, nil
+	default:
+		return false, false, nil
+	}
+
+	for _, value := range values {
+		match, err := compare(value)
+		if err != nil {
+			return false, true, fmt.Errorf("value %q of %s is not a valid %s: %w", value, cond.CompositeKey, typ, err)
 		}
+		if match {
+			return true, true, nil
+		}
+	}
+	return false, true, nil
+}
 
-	case reflect.Int64:
-		var v int64
+func compareTimes(op Operator, v, operand time.Time) bool {
+	switch op {
+	case OpLessEqual:
+		return v.Before(operand) || v.Equal(operand)
+	case OpGreaterEqual:
+		return v.Equal(operand) || v.After(operand)
+	case OpLess:
+		return v.Before(operand)
+	case OpGreater:
+		return v.After(operand)
+	case OpEqual:
+		return v.Equal(operand)
+	}
+	return false
+}
 
-		operandInt := operand.Interface().(int64)
+func compareFloats(op Operator, v, operand float64) bool {
+	switch op {
+	case OpLessEqual:
+		return v <= operand
+	case OpGreaterEqual:
+		return v >= operand
+	case OpLess:
+		return v < operand
+	case OpGreater:
+		return v > operand
+	case OpEqual:
+		return v == operand
+	}
+	return false
+}
+
+func compareInts(op Operator, v, operand int64) bool {
+	switch op {
+	case OpLessEqual:
+		return v <= operand
+	case OpGreaterEqual:
+		return v >= operand
+	case OpLess:
+		return v < operand
+	case OpGreater:
+		return v > operand
+	case OpEqual:
+		return v == operand
+	}
+	return false
+}
+
+// maxMemoValues bounds the number of distinct values a valueMemo remembers.
+const maxMemoValues = 1024
+
+// A valueMemo remembers the results of parsing event values as times and
+// numbers, for the duration of a single match, since batches of events often
+// repeat values, such as heights and times. It holds at most maxMemoValues
+// values. A nil valueMemo parses every value afresh.
+type valueMemo map[memoKey]parsedValue
+
+type memoKey struct {
+	kind  reflect.Kind // of the operand the value is compared to
+	value string
+}
+
+type parsedValue struct {
+	time  time.Time
+	float float64
+	int   int64
+	err   error
+}
+
+// parse returns the result of parsing value for comparison with an operand of
+// the given kind, from the memo if possible.
+func (m valueMemo) parse(kind reflect.Kind, value string) parsedValue {
+	key := memoKey{kind: kind, value: value}
+	if v, ok := m[key]; ok {
+		return v
+	}
+	v := parseValue(kind, value)
+	if m != nil && len(m) < maxMemoValues {
+		m[key] = v
+	}
+	return v
+}
+
+func (m valueMemo) time(value string) (time.Time, error) {
+	v := m.parse(reflect.Struct, value)
+	return v.time, v.err
+}
+
+func (m valueMemo) float(value string) (float64, error) {
+	v := m.parse(reflect.Float64, value)
+	return v.float, v.err
+}
+
+func (m valueMemo) int(value string) (int64, error) {
+	v := m.parse(reflect.Int64, value)
+	return v.int, v.err
+}
+
+// parseValue converts value for comparison with an operand of the given kind.
+func parseValue(kind reflect.Kind, value string) (v parsedValue) {
+	switch kind {
+	case reflect.Struct: // time
+		t, err := parseTime(value)
+		if err != nil {
+			v.err = fmt.Errorf("failed to convert value %v from event attribute to time.Time: %w", value, err)
+		}
+		v.time = t
+
+	case reflect.Float64:
+		filteredValue := numRegex.FindString(value)
+
+		// try our best to convert value from tags to float64
+		f, err := strconv.ParseFloat(filteredValue, 64)
+		if err != nil {
+			v.err = fmt.Errorf("failed to convert value %v from event attribute to float64: %w", filteredValue, err)
+		}
+		v.float = f
+
+	case reflect.Int64:
 		filteredValue := numRegex.FindString(value)
 
 		// if value looks like float, we try to parse it as float
 		if strings.ContainsAny(filteredValue, ".") {
-			v1, err := strconv.ParseFloat(filteredValue, 64)
+			f, err := strconv.ParseFloat(filteredValue, 64)
 			if err != nil {
-				return false, fmt.Errorf("failed to convert value %v from event attribute to float64: %w", filteredValue, err)
+				v.err = fmt.Errorf("failed to convert value %v from event attribute to float64: %w", filteredValue, err)
 			}
-
-			v = int64(v1)
+			v.int = int64(f)
 		} else {
-			var err error
 			// try our best to convert value from tags to int64
-			v, err = strconv.ParseInt(filteredValue, 10, 64)
+			i, err := strconv.ParseInt(filteredValue, 10, 64)
 			if err != nil {
-				return false, fmt.Errorf("failed to convert value %v from event attribute to int64: %w", filteredValue, err)
+				v.err = fmt.Errorf("failed to convert value %v from event attribute to int64: %w", filteredValue, err)
 			}
+			v.int = i
 		}
+	}
+	return v
+}
 
-		switch op {
-		case OpLessEqual:
-			return v <= operandInt, nil
-		case OpGreaterEqual:
-			return v >= operandInt, nil
-		case OpLess:
-			return v < operandInt, nil
-		case OpGreater:
-			return v > operandInt, nil
-		case OpEqual:
-			return v == operandInt, nil
+// parseTime tries its best to convert a value from events to time.Time.
+func parseTime(value string) (time.Time, error) {
+	if strings.ContainsAny(value, "T") {
+		return time.Parse(TimeLayout, value)
+	}
+	return time.Parse(DateLayout, value)
+}
+
+// A resultCache holds the results of Matches by fingerprint of the events.
+// It is safe for concurrent use.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	results map[[sha256.Size]byte]bool
+}
+
+func (c *resultCache) get(key [sha256.Size]byte) (match, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	match, ok = c.results[key]
+	return match, ok
+}
+
+// put records a result, evicting an arbitrary entry if the cache is full.
+func (c *resultCache) put(key [sha256.Size]byte, match bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.results) >= c.size {
+		for k := range c.results {
+			delete(c.results, k)
+			break
 		}
+	}
+	if c.size > 0 {
+		c.results[key] = match
+	}
+}
 
-	case reflect.String:
-		switch op {
-		case OpEqual:
-			return value == operand.String(), nil
-		case OpContains:
-			return strings.Contains(value, operand.String()), nil
+// fingerprint returns a digest of the types, keys and values of events. As the
+// values may come from transactions, the hash must be collision resistant, lest
+// crafted events be given the cached result of others.
+func fingerprint(events []types.Event) [sha256.Size]byte {
+	h := sha256.New()
+	var buf [binary.MaxVarintLen64]byte
+	write := func(s string) { // length-prefixed, so that fields cannot run together
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+
+	for _, event := range events {
+		write(event.Type)
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(event.Attributes)))])
+		for _, attr := range event.Attributes {
+			write(attr.Key)
+			write(attr.Value)
 		}
+	}
 
-	default:
-		return false, fmt.Errorf("unknown kind of operand %v", operand.Kind())
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// trimSymbols removes a leading and a trailing occurrence of any of symbols
+// from value.
+func trimSymbols(value string, symbols []string) string {
+	for _, symbol := range symbols {
+		if symbol != "" && strings.HasPrefix(value, symbol) {
+			value = value[len(symbol):]
+			break
+		}
+	}
+	for _, symbol := range symbols {
+		if symbol != "" && strings.HasSuffix(value, symbol) {
+			value = value[:len(value)-len(symbol)]
+			break
+		}
 	}
+	return value
+}
 
-	return false, nil
+// flatten flattens events for matching against q. If all the conditions of q
+// refer to events of one type, events of other types are skipped. The type of
+// a key is taken to be the part before its first ".", so events whose type is
+// that part followed by a "." and more are kept too: "a.b.c" may be attribute
+// "b.c" of an event of type "a" or attribute "c" of an event of type "a.b".
+func (q *Query) flatten(events []types.Event) map[string][]string {
+	if q.eventType == "" {
+		return flattenEvents(events, q.exclude)
+	}
+
+	var relevant []types.Event
+	for _, event := range events {
+		if strings.HasPrefix(event.Type, q.eventType) &&
+			(len(event.Type) == len(q.eventType) || event.Type[len(q.eventType)] == '.') {
+			relevant = append(relevant, event)
+		}
+	}
+	return flattenEvents(relevant, q.exclude)
 }
 
-func flattenEvents(events []types.Event) map[string][]string {
+func flattenEvents(events []types.Event, exclude map[string]struct{}) map[string][]string {
 	flattened := make(map[string][]string)
 
 	for _, event := range events {
 		if len(event.Type) == 0 {
 			continue
 		}
+		if _, ok := exclude[event.Type]; ok {
+			continue
+		}
 
 		for _, attr := range event.Attributes {
 			if len(attr.Key) == 0 {
@@ -1,6 +1,7 @@
 package query_test
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -53,6 +54,10 @@ func TestMatches(t *testing.T) {
 		{"transfer.amount > 7", map[string][]string{"transfer.amount": {"8.045stake"}}, false, true, false},
 		{"transfer.amount > 7.043", map[string][]string{"transfer.amount": {"8.045stake"}}, false, true, false},
 		{"transfer.amount > 8.045", map[string][]string{"transfer.amount": {"8.045stake"}}, false, false, false},
+		{"swap.rate > 10", map[string][]string{"swap.rate": {"12.5%"}}, false, true, false},
+		{"swap.rate < 12.5", map[string][]string{"swap.rate": {"12.5%"}}, false, false, false},
+		{"tx.fee = 1.5", map[string][]string{"tx.fee": {"$1.50"}}, false, true, false},
+		{"tx.fee < 2", map[string][]string{"tx.fee": {"€1.50"}}, false, true, false},
 		{"tx.gas > 7 AND tx.gas < 9", map[string][]string{"tx.gas": {"8"}}, false, true, false},
 		{"body.weight >= 3.5", map[string][]string{"body.weight": {"3.5"}}, false, true, false},
 		{"account.balance < 1000.0", map[string][]string{"account.balance": {"900"}}, false, true, false},
@@ -201,6 +206,243 @@ func TestMustParse(t *testing.T) {
 	require.NotPanics(t, func() { query.MustParse("tm.events.type='NewBlock'") })
 }
 
+func TestMatchesFlat(t *testing.T) {
+	q := query.MustParse("transfer.sender = 'alice' AND transfer.amount > 10")
+
+	// Neither matches on its own.
+	batch1 := []abci.Event{{Type: "transfer", Attributes: []abci.EventAttribute{
+		{Key: "sender", Value: "alice"}, {Key: "amount", Value: "5"},
+	}}}
+	batch2 := []abci.Event{{Type: "transfer", Attributes: []abci.EventAttribute{
+		{Key: "sender", Value: "bob"}, {Key: "amount", Value: "20"},
+	}}}
+	for _, batch := range [][]abci.Event{batch1, batch2} {
+		match, err := q.Matches(batch)
+		require.NoError(t, err)
+		require.False(t, match)
+	}
+
+	// Per-event matching already disregards event boundaries within a batch.
+	match, err := q.Matches(append(batch1, batch2...))
+	require.NoError(t, err)
+	require.True(t, match)
+
+	// Merging the batches loses their boundaries too.
+	merged := map[string][]string{
+		"transfer.sender": {"alice", "bob"},
+		"transfer.amount": {"5", "20"},
+	}
+	match, err = q.MatchesFlat(merged)
+	require.NoError(t, err)
+	require.True(t, match)
+
+	match, err = q.MatchesFlat(map[string][]string{
+		"transfer.sender": {"alice"},
+		"transfer.amount": {"5"},
+	})
+	require.NoError(t, err)
+	require.False(t, match)
+
+	match, err = q.MatchesFlat(nil)
+	require.NoError(t, err)
+	require.False(t, match)
+
+	excluding, err := query.New("transfer.sender EXISTS", query.ExcludeTypes([]string{"transfer"}))
+	require.NoError(t, err)
+	match, err = excluding.MatchesFlat(merged)
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestDefaultType(t *testing.T) {
+	events := expandEvents(map[string][]string{
+		"tx.height":       {"7"},
+		"tx.sender":       {"alice"},
+		"transfer.sender": {"bob"},
+	})
+
+	testCases := []struct {
+		s       string
+		matches bool
+	}{
+		{"height > 5", true},
+		{"height > 5 AND sender = 'alice'", true},
+		{"sender = 'bob'", false},
+		// tags with a type override the default
+		{"height > 5 AND transfer.sender = 'bob'", true},
+		{"tx.sender = 'bob'", false},
+		// EXISTS on a bare name resolves under the default type
+		{"sender EXISTS", true},
+		{"recipient EXISTS", false},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, query.DefaultType("tx"))
+		require.NoError(t, err)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q", tc.s)
+	}
+
+	q, err := query.New("height > 5 AND transfer.sender EXISTS", query.DefaultType("tx"))
+	require.NoError(t, err)
+	conditions, err := q.Conditions()
+	require.NoError(t, err)
+	require.Equal(t, []query.Condition{
+		{CompositeKey: "tx.height", Op: query.OpGreater, Operand: int64(5)},
+		{CompositeKey: "transfer.sender", Op: query.OpExists},
+	}, conditions)
+
+	// Without a default type, EXISTS on a bare name matches by prefix.
+	q, err = query.New("trans EXISTS")
+	require.NoError(t, err)
+	match, err := q.Matches(events)
+	require.NoError(t, err)
+	require.True(t, match)
+	q, err = query.New("trans EXISTS", query.DefaultType("tx"))
+	require.NoError(t, err)
+	match, err = q.Matches(events)
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestConditionString(t *testing.T) {
+	q := query.MustParse("tx.gas > 7 AND tx.fee = 1.5 AND tx.memo CONTAINS 'hi' AND " +
+		"tx.date >= DATE 2013-05-03 AND tx.time < TIME 2013-05-03T14:45:00Z AND tx.hash EXISTS")
+	conditions, err := q.Conditions()
+	require.NoError(t, err)
+
+	var strs []string
+	for _, cond := range conditions {
+		strs = append(strs, cond.String())
+	}
+	require.Equal(t, []string{
+		"tx.gas > 7",
+		"tx.fee = 1.5",
+		"tx.memo CONTAINS 'hi'",
+		"tx.date >= TIME 2013-05-03T00:00:00Z",
+		"tx.time < TIME 2013-05-03T14:45:00Z",
+		"tx.hash EXISTS",
+	}, strs)
+
+	require.Equal(t, "tx.fee = 1.0", query.Condition{CompositeKey: "tx.fee", Op: query.OpEqual, Operand: 1.0}.String())
+
+	// The same instant in another zone renders the same.
+	utc := time.Date(2013, 5, 3, 14, 45, 0, 0, time.UTC)
+	cond := query.Condition{CompositeKey: "tx.time", Op: query.OpLess, Operand: utc.In(time.FixedZone("UTC+2", 7200))}
+	require.Equal(t, "tx.time < TIME 2013-05-03T14:45:00Z", cond.String())
+}
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		name           string
+		a, b           string
+		added, removed []string
+	}{
+		{
+			"Same",
+			"tm.event = 'Tx' AND tx.height > 5",
+			"tx.height  >  5 AND tm.event='Tx'",
+			nil, nil,
+		},
+		{
+			"Add",
+			"tm.event = 'Tx'",
+			"tm.event = 'Tx' AND tx.height > 5",
+			[]string{"tx.height > 5"}, nil,
+		},
+		{
+			"Remove",
+			"tm.event = 'Tx' AND tx.height > 5 AND tx.hash EXISTS",
+			"tm.event = 'Tx'",
+			nil, []string{"tx.height > 5", "tx.hash EXISTS"},
+		},
+		{
+			"Modify",
+			"tm.event = 'Tx' AND tx.height > 5",
+			"tm.event = 'Tx' AND tx.height >= 5",
+			[]string{"tx.height >= 5"}, []string{"tx.height > 5"},
+		},
+		{
+			"Repeated",
+			"tx.height > 5 AND tx.height > 5",
+			"tx.height > 5",
+			nil, []string{"tx.height > 5"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			added, removed, err := query.Diff(query.MustParse(tc.a), query.MustParse(tc.b))
+			require.NoError(t, err)
+			require.Equal(t, tc.added, added, "added")
+			require.Equal(t, tc.removed, removed, "removed")
+		})
+	}
+}
+
+func TestMaxConditions(t *testing.T) {
+	conditions := func(n int) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = fmt.Sprintf("tx.gas > %d", i)
+		}
+		return strings.Join(parts, " AND ")
+	}
+
+	_, err := query.New(conditions(3), query.MaxConditions(3))
+	require.NoError(t, err)
+	_, err = query.New(conditions(4), query.MaxConditions(3))
+	require.ErrorIs(t, err, query.ErrTooManyConditions)
+
+	_, err = query.New(conditions(query.DefaultMaxConditions))
+	require.NoError(t, err)
+	_, err = query.New(conditions(query.DefaultMaxConditions + 1))
+	require.ErrorIs(t, err, query.ErrTooManyConditions)
+	_, err = query.New(conditions(query.DefaultMaxConditions+1), query.MaxConditions(0))
+	require.NoError(t, err)
+
+	_, err = query.NewWithArgs("tx.gas > $1 AND tx.gas < $2", 1, 10, query.MaxConditions(1))
+	require.ErrorIs(t, err, query.ErrTooManyConditions)
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		s     string
+		valid bool
+	}{
+		{"tm.events.type='NewBlock'", true},
+		{"tx.gas > 7 AND tx.gas < 9", true},
+		{"tx.date >= DATE 2013-05-03", true},
+		{"tx.time = TIME 2013-05-03T14:45:00Z", true},
+		{"slashing EXISTS", true},
+		{"tx.memo CONTAINS 'hello'", true},
+		{"", false},
+		{"tx.gas >", false},
+		{"tx.gas > 'seven'", false},
+		{"tx.memo CONTAINS 7", false},
+		{"tx.gas > 7 OR tx.gas < 9", false},
+		{"tx.gas = 99999999999999999999", false},
+	}
+
+	for _, tc := range testCases {
+		err := query.Validate(tc.s)
+		if !tc.valid {
+			require.Error(t, err, "query %q", tc.s)
+			continue
+		}
+		require.NoError(t, err, "query %q", tc.s)
+
+		// Valid queries are accepted by New, and can be matched.
+		q, err := query.New(tc.s)
+		require.NoError(t, err, "query %q", tc.s)
+		_, err = q.Conditions()
+		require.NoError(t, err, "query %q", tc.s)
+	}
+}
+
 func TestConditions(t *testing.T) {
 	txTime, err := time.Parse(time.RFC3339, "2013-05-03T14:45:00Z")
 	require.NoError(t, err)
@@ -245,3 +487,1026 @@ func TestConditions(t *testing.T) {
 		require.Equal(t, tc.conditions, c)
 	}
 }
+
+func TestExplain(t *testing.T) {
+	events := expandEvents(map[string][]string{
+		"tm.event":     {"Tx"},
+		"tx.gas":       {"8"},
+		"slash.reason": {"missing_signature"},
+	})
+
+	testCases := []struct {
+		s           string
+		events      []abci.Event
+		matched     bool
+		failedIndex int
+		reason      string
+	}{
+		{"tm.event = 'Tx' AND tx.gas > 7", events, true, -1, ""},
+		{"tm.event = 'NewBlock' AND tx.gas > 7", events, false, 0, `no value of "tm.event" satisfies = NewBlock`},
+		{"tm.event = 'Tx' AND tx.gas > 8", events, false, 1, `no value of "tx.gas" satisfies > 8`},
+		{"tm.event = 'Tx' AND tx.fee < 8", events, false, 1, `event attribute "tx.fee" not found`},
+		{"tm.event = 'Tx' AND slash EXISTS AND transfer EXISTS", events, false, 2, `no event attribute matching "transfer" exists`},
+		{"tm.event = 'Tx'", nil, false, -1, "no events"},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s)
+		require.NoError(t, err)
+
+		matched, failedIndex, reason := q.Explain(tc.events)
+		require.Equal(t, tc.matched, matched, "query %q", tc.s)
+		require.Equal(t, tc.failedIndex, failedIndex, "query %q", tc.s)
+		require.Contains(t, reason, tc.reason, "query %q", tc.s)
+
+		match, err := q.Matches(tc.events)
+		require.NoError(t, err)
+		require.Equal(t, matched, match, "query %q", tc.s)
+	}
+}
+
+func TestMatchingEventIndices(t *testing.T) {
+	events := []abci.Event{
+		{Type: "transfer", Attributes: []abci.EventAttribute{
+			{Key: "sender", Value: "addr1"}, {Key: "amount", Value: "100"},
+		}},
+		{Type: "transfer", Attributes: []abci.EventAttribute{
+			{Key: "sender", Value: "addr2"}, {Key: "amount", Value: "5"},
+		}},
+		{Type: "message", Attributes: []abci.EventAttribute{
+			{Key: "action", Value: "send"},
+		}},
+		{Type: "transfer", Attributes: []abci.EventAttribute{
+			{Key: "sender", Value: "addr1"}, {Key: "amount", Value: "lots"},
+		}},
+	}
+
+	testCases := []struct {
+		s    string
+		want [][]int
+	}{
+		{"transfer.sender = 'addr1'", [][]int{{0, 3}}},
+		{"transfer.sender = 'addr1' AND transfer.amount > 10", [][]int{{0, 3}, {0}}},
+		{"transfer.amount < 10 AND message.action = 'send'", [][]int{{1}, {2}}},
+		{"transfer EXISTS AND message.action = 'receive'", [][]int{{0, 1, 3}, {}}},
+		{"transfer.sender CONTAINS 'addr'", [][]int{{0, 1, 3}}},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, q.MatchingEventIndices(events), "query %q", tc.s)
+	}
+}
+
+func TestSelectivity(t *testing.T) {
+	stats := query.TagStats{
+		"tx.hash":   100000,
+		"tx.sender": 500,
+		"tm.event":  3,
+	}
+
+	testCases := []struct {
+		s    string
+		want []int
+	}{
+		{"tx.memo CONTAINS 'abc' AND tx.hash = 'ABCD'", []int{1, 0}},
+		{"tm.event = 'Tx' AND tx.sender = 'addr' AND tx.hash = 'ABCD'", []int{2, 1, 0}},
+		{"tm.event = 'Tx' AND tx.height > 5", []int{1, 0}},
+		{"tx.fee EXISTS AND tx.memo CONTAINS 'abc' AND tx.height > 5", []int{2, 1, 0}},
+		// unknown cardinality
+		{"tx.memo CONTAINS 'abc' AND tx.code = 0", []int{1, 0}},
+		// ties keep the query order
+		{"tx.height > 5 AND tx.gas < 10", []int{0, 1}},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, q.Selectivity(stats), "query %q", tc.s)
+	}
+}
+
+func TestMissingNumbers(t *testing.T) {
+	withFee := expandEvents(map[string][]string{
+		"tm.event":   {"Tx"},
+		"fee.amount": {"5"},
+	})
+	withoutFee := expandEvents(map[string][]string{
+		"tm.event": {"Tx"},
+	})
+
+	testCases := []struct {
+		s       string
+		policy  query.MissingPolicy
+		events  []abci.Event
+		matches bool
+	}{
+		{"fee.amount > 0", query.MissingNoMatch, withFee, true},
+		{"fee.amount > 0", query.MissingNoMatch, withoutFee, false},
+		{"fee.amount < 10", query.MissingNoMatch, withoutFee, false},
+		{"fee.amount = 0", query.MissingNoMatch, withoutFee, false},
+
+		{"fee.amount > 0", query.MissingAsZero, withFee, true},
+		{"fee.amount > 0", query.MissingAsZero, withoutFee, false},
+		{"fee.amount < 10", query.MissingAsZero, withFee, true},
+		{"fee.amount < 10", query.MissingAsZero, withoutFee, true},
+		{"fee.amount = 0", query.MissingAsZero, withoutFee, true},
+		{"fee.amount >= 1.5", query.MissingAsZero, withoutFee, false},
+		{"fee.amount <= 1.5", query.MissingAsZero, withoutFee, true},
+		// non-numeric conditions are unaffected
+		{"fee.denom = 'stake'", query.MissingAsZero, withoutFee, false},
+		{"fee.amount EXISTS", query.MissingAsZero, withoutFee, false},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, query.MissingNumbers(tc.policy))
+		require.NoError(t, err)
+
+		match, err := q.Matches(tc.events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q (policy %d)", tc.s, tc.policy)
+	}
+}
+
+func TestMissingStrings(t *testing.T) {
+	withMemo := expandEvents(map[string][]string{
+		"tm.event": {"Tx"},
+		"tx.memo":  {"hello"},
+	})
+	withEmptyMemo := expandEvents(map[string][]string{
+		"tm.event": {"Tx"},
+		"tx.memo":  {""},
+	})
+	withoutMemo := expandEvents(map[string][]string{
+		"tm.event": {"Tx"},
+	})
+	missingEmpty := []query.Option{query.MissingStrings("")}
+	missingNone := []query.Option{query.MissingStrings("none")}
+
+	testCases := []struct {
+		s       string
+		options []query.Option
+		events  []abci.Event
+		matches bool
+	}{
+		// present, empty
+		{"tx.memo = ''", nil, withEmptyMemo, true},
+		{"tx.memo = ''", missingEmpty, withEmptyMemo, true},
+		{"tx.memo = ''", missingEmpty, withMemo, false},
+		{"tx.memo = 'hello'", missingEmpty, withMemo, true},
+
+		// absent, with a default
+		{"tx.memo = ''", missingEmpty, withoutMemo, true},
+		{"tx.memo = 'none'", missingNone, withoutMemo, true},
+		{"tx.memo = ''", missingNone, withoutMemo, false},
+
+		// absent, without a default
+		{"tx.memo = ''", nil, withoutMemo, false},
+		{"tx.memo = 'none'", nil, withoutMemo, false},
+
+		// other operators and operands are unaffected
+		{"tx.memo CONTAINS ''", missingEmpty, withoutMemo, false},
+		{"tx.memo EXISTS", missingEmpty, withoutMemo, false},
+		{"tx.memo = 0", missingEmpty, withoutMemo, false},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, tc.options...)
+		require.NoError(t, err)
+
+		match, err := q.Matches(tc.events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q (%d options)", tc.s, len(tc.options))
+	}
+}
+
+func TestSingleEventType(t *testing.T) {
+	events := []abci.Event{
+		{Type: "tx", Attributes: []abci.EventAttribute{{Key: "height", Value: "5"}}},
+		{Type: "tx.fee", Attributes: []abci.EventAttribute{{Key: "amount", Value: "10"}}},
+		{Type: "txs", Attributes: []abci.EventAttribute{{Key: "count", Value: "3"}}},
+		{Type: "transfer", Attributes: []abci.EventAttribute{{Key: "amount", Value: "7"}}},
+	}
+
+	testCases := []struct {
+		s       string
+		options []query.Option
+		matches bool
+	}{
+		{"tx.height = 5", nil, true},
+		{"tx.height = 5 AND tx.fee.amount = 10", nil, true},
+		{"tx.fee.amount = 10", nil, true},
+		{"tx.count = 3", nil, false},
+		{"txs.count = 3", nil, true},
+		{"tx.height = 5 AND transfer.amount = 7", nil, true},
+		{"tx*.count = 3", nil, true},
+		{"height = 5", []query.Option{query.DefaultType("tx")}, true},
+		{"amount = 7", []query.Option{query.DefaultType("transfer")}, true},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, tc.options...)
+		require.NoError(t, err)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q", tc.s)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := query.Schema(map[string]query.ValueType{
+		"tx.height":  query.ValueInt,
+		"tx.fee":     query.ValueFloat,
+		"tx.time":    query.ValueTime,
+		"tx.success": query.ValueBool,
+	})
+
+	testCases := []struct {
+		s        string
+		events   map[string][]string
+		generic  bool // whether the query matches without the schema
+		typed    bool // whether it matches with the schema
+		typedErr bool // whether matching fails with the schema
+	}{
+		{"tx.height > 5", map[string][]string{"tx.height": {"100"}}, true, true, false},
+		// the generic path drops the sign
+		{"tx.height > 5", map[string][]string{"tx.height": {"-100"}}, true, false, false},
+		{"tx.height = 100", map[string][]string{"tx.height": {"3", "100"}}, true, true, false},
+		{"tx.height > 5.5", map[string][]string{"tx.height": {"6"}}, true, true, false},
+		// the generic path takes the first number in the value
+		{"tx.height > 5", map[string][]string{"tx.height": {"100stake"}}, true, false, true},
+		{"tx.height = 100", map[string][]string{"tx.height": {"100.7"}}, true, false, true},
+		{"tx.fee < 1.5", map[string][]string{"tx.fee": {"1.25"}}, true, true, false},
+		{"tx.fee < 2", map[string][]string{"tx.fee": {"1.25"}}, true, true, false},
+		{"tx.fee < 1.5", map[string][]string{"tx.fee": {"$1.25"}}, true, false, true},
+		{
+			"tx.time > TIME 2013-05-03T14:45:00Z",
+			map[string][]string{"tx.time": {"2013-05-03T14:46:00Z"}}, true, true, false,
+		},
+		{"tx.success = 'true'", map[string][]string{"tx.success": {"true"}}, true, true, false},
+		{"tx.success = 'true'", map[string][]string{"tx.success": {"1"}}, false, true, false},
+		{"tx.success = 'false'", map[string][]string{"tx.success": {"true"}}, false, false, false},
+		{"tx.success = 'true'", map[string][]string{"tx.success": {"yes"}}, false, false, true},
+		// operands of other types are compared as usual
+		{"tx.height = '100stake'", map[string][]string{"tx.height": {"100stake"}}, true, true, false},
+		{"tx.height CONTAINS 'stake'", map[string][]string{"tx.height": {"100stake"}}, true, true, false},
+		// undeclared attributes are matched as usual
+		{"tx.gas > 5", map[string][]string{"tx.gas": {"100stake"}}, true, true, false},
+	}
+
+	for _, tc := range testCases {
+		events := expandEvents(tc.events)
+
+		match, err := query.MustParse(tc.s).Matches(events)
+		require.NoError(t, err, "query %q", tc.s)
+		require.Equal(t, tc.generic, match, "query %q", tc.s)
+
+		q, err := query.New(tc.s, schema)
+		require.NoError(t, err)
+		match, err = q.Matches(events)
+		if tc.typedErr {
+			require.Error(t, err, "query %q", tc.s)
+			continue
+		}
+		require.NoError(t, err, "query %q", tc.s)
+		require.Equal(t, tc.typed, match, "query %q with schema", tc.s)
+	}
+}
+
+func TestNumericSymbolsSchema(t *testing.T) {
+	schema := query.Schema(map[string]query.ValueType{
+		"swap.rate": query.ValueFloat,
+		"tx.fee":    query.ValueFloat,
+		"tx.height": query.ValueInt,
+	})
+	symbols := query.NumericSymbols("%", "$", "€")
+
+	testCases := []struct {
+		s       string
+		value   string
+		err     bool // whether matching fails without NumericSymbols
+		match   bool // whether it matches with NumericSymbols
+		normErr bool // whether matching fails with NumericSymbols
+	}{
+		{"swap.rate > 10", "12.5%", true, true, false},
+		{"swap.rate < 12.5", "12.5%", true, false, false},
+		{"tx.fee = 1.5", "$1.50", true, true, false},
+		{"tx.fee < 2", "€1.50", true, true, false},
+		{"tx.height = 100", "$100%", true, true, false},
+		// plain numbers are unaffected
+		{"swap.rate > 10", "12.5", false, true, false},
+		{"tx.height = 100", "100", false, true, false},
+		// symbols that are not recognized, or not at either end, still fail
+		{"tx.fee < 2", "£1.50", true, false, true},
+		{"tx.fee < 2", "1.5$0", true, false, true},
+	}
+
+	for _, tc := range testCases {
+		events := expandEvents(map[string][]string{strings.Fields(tc.s)[0]: {tc.value}})
+
+		q, err := query.New(tc.s, schema)
+		require.NoError(t, err)
+		_, err = q.Matches(events)
+		require.Equal(t, tc.err, err != nil, "query %q, value %q", tc.s, tc.value)
+
+		q, err = query.New(tc.s, schema, symbols)
+		require.NoError(t, err)
+		match, err := q.Matches(events)
+		if tc.normErr {
+			require.Error(t, err, "query %q, value %q", tc.s, tc.value)
+			continue
+		}
+		require.NoError(t, err, "query %q, value %q", tc.s, tc.value)
+		require.Equal(t, tc.match, match, "query %q, value %q", tc.s, tc.value)
+	}
+}
+
+func TestMatchesContext(t *testing.T) {
+	events := expandEvents(map[string][]string{"tm.event": {"Tx"}, "tx.height": {"5"}})
+	q := query.MustParse("tm.event = 'Tx' AND tx.height = 5")
+
+	match, err := q.MatchesContext(context.Background(), events)
+	require.NoError(t, err)
+	require.True(t, match)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	match, err = q.MatchesContext(ctx, events)
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, match)
+
+	// A match of the last condition that completes after the context ends is
+	// not reported either.
+	match, err = q.MatchesContext(&expiringContext{Context: context.Background(), checks: 3}, events)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, match)
+}
+
+// expiringContext is a context whose Err reports that its deadline has passed
+// once it has been checked the given number of times.
+type expiringContext struct {
+	context.Context
+	checks int
+}
+
+func (c *expiringContext) Err() error {
+	if c.checks > 0 {
+		c.checks--
+		return nil
+	}
+	return context.DeadlineExceeded
+}
+
+func TestTypePrefix(t *testing.T) {
+	events := []abci.Event{
+		{Type: "ibc_transfer", Attributes: []abci.EventAttribute{
+			{Key: "channel", Value: "channel-0"}, {Key: "amount", Value: "10"},
+		}},
+		{Type: "ibc_client", Attributes: []abci.EventAttribute{
+			{Key: "channel", Value: "channel-7"}, {Key: "height", Value: "100"},
+		}},
+		{Type: "transfer", Attributes: []abci.EventAttribute{
+			{Key: "channel", Value: "channel-9"}, {Key: "amount", Value: "1000"},
+		}},
+		{Type: "ibc_relay", Attributes: []abci.EventAttribute{
+			{Key: "memo.channel", Value: "channel-3"},
+		}},
+	}
+
+	testCases := []struct {
+		s       string
+		matches bool
+	}{
+		// values are pooled across the family of types
+		{"ibc*.channel = 'channel-0'", true},
+		{"ibc*.channel = 'channel-7'", true},
+		{"ibc*.channel = 'channel-9'", false},
+		{"ibc*.amount > 5", true},
+		{"ibc*.amount > 100", false},
+		{"ibc_*.height = 100", true},
+		{"ibc_t*.height = 100", false},
+		{"ibc*.channel = 'channel-0' AND ibc*.height = 100", true},
+		{"ibc*.memo EXISTS", false},
+		{"ibc*.height EXISTS", true},
+		{"ibc* EXISTS", true},
+		{"ics* EXISTS", false},
+		{"*.amount > 500", true},
+		{"ibc*.channel CONTAINS 'channel'", true},
+		// the attribute key is matched in full
+		{"ibc*.channel = 'channel-3'", false},
+		{"ibc*.memo.channel = 'channel-3'", true},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s)
+		require.NoError(t, err, "query %q", tc.s)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q", tc.s)
+	}
+}
+
+func TestExcludeTypes(t *testing.T) {
+	events := []abci.Event{
+		{Type: "tm", Attributes: []abci.EventAttribute{{Key: "event", Value: "NewBlock"}}},
+		{Type: "transfer", Attributes: []abci.EventAttribute{{Key: "amount", Value: "10"}}},
+		{Type: "transfers", Attributes: []abci.EventAttribute{{Key: "count", Value: "1"}}},
+	}
+
+	testCases := []struct {
+		s       string
+		exclude []string
+		matches bool
+	}{
+		{"tm.event = 'NewBlock'", nil, true},
+		{"tm.event = 'NewBlock'", []string{"tm"}, false},
+		{"transfer.amount > 5", []string{"tm"}, true},
+		{"tm.event = 'NewBlock' AND transfer.amount > 5", []string{"tm"}, false},
+		{"transfer.amount > 5", []string{"tm", "transfer"}, false},
+		{"transfers.count = 1", []string{"transfer"}, true},
+		// bare names match the remaining types by prefix
+		{"transfer EXISTS", []string{"transfer"}, true},
+		{"transfer EXISTS", []string{"transfer", "transfers"}, false},
+		{"tm EXISTS", []string{"tm"}, false},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, query.ExcludeTypes(tc.exclude))
+		require.NoError(t, err)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q (exclude %q)", tc.s, tc.exclude)
+	}
+}
+
+func TestTrimSpace(t *testing.T) {
+	events := expandEvents(map[string][]string{
+		"tx.memo":   {"  hello world\t"},
+		"tx.sender": {"addr1\n"},
+		"tx.gas":    {" 8 "},
+		"tx.date":   {"2013-05-03"},
+	})
+
+	testCases := []struct {
+		s       string
+		trim    bool
+		matches bool
+	}{
+		{"tx.memo = 'hello world'", false, false},
+		{"tx.memo = 'hello world'", true, true},
+		{"tx.memo = ' hello world  '", true, true},
+		{"tx.memo = 'hello'", true, false},
+		{"tx.memo CONTAINS '  hello'", false, true},
+		{"tx.memo CONTAINS ' world'", true, true},
+		{"tx.memo CONTAINS 'world '", true, true},
+		{"tx.memo CONTAINS 'worlds'", true, false},
+		{"tx.sender = 'addr1'", true, true},
+		// numeric and date conditions are unaffected
+		{"tx.gas = 8", false, true},
+		{"tx.gas = 8", true, true},
+		{"tx.date = DATE 2013-05-03", true, true},
+	}
+
+	for _, tc := range testCases {
+		var opts []query.Option
+		if tc.trim {
+			opts = append(opts, query.TrimSpace())
+		}
+		q, err := query.New(tc.s, opts...)
+		require.NoError(t, err)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q (trim %v)", tc.s, tc.trim)
+	}
+
+	q, err := query.NewWithArgs("tx.sender = $1", map[string]struct{}{"addr1": {}}, query.TrimSpace())
+	require.NoError(t, err)
+	match, err := q.Matches(events)
+	require.NoError(t, err)
+	require.True(t, match)
+}
+
+func TestTrimSpaceTypes(t *testing.T) {
+	opts := []query.Option{
+		query.TrimSpace(),
+		query.Schema(map[string]query.ValueType{"tx.ok": query.ValueBool}),
+		query.Enum("account.status", map[string]int{"ACTIVE": 1}),
+	}
+
+	// Trimmed values are compared as declared by Schema and Enum.
+	testCases := []struct {
+		s       string
+		value   string
+		matches bool
+		err     bool
+	}{
+		{"tx.ok = 'true'", " true\n", true, false},
+		{"tx.ok = ' true '", "true", true, false},
+		{"tx.ok = 'true'", " yes ", false, true},
+		{"account.status = ' ACTIVE '", "1", true, false},
+		{"account.status = 'ACTIVE'", " 1 ", true, false},
+		{"account.status = 1", "ACTIVE\t", true, false},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, opts...)
+		require.NoError(t, err)
+
+		events := expandEvents(map[string][]string{strings.Fields(tc.s)[0]: {tc.value}})
+		match, err := q.Matches(events)
+		if tc.err {
+			require.Error(t, err, "query %q against %q", tc.s, tc.value)
+			continue
+		}
+		require.NoError(t, err, "query %q against %q", tc.s, tc.value)
+		require.Equal(t, tc.matches, match, "query %q against %q", tc.s, tc.value)
+	}
+}
+
+func TestNumericSymbols(t *testing.T) {
+	symbols := query.NumericSymbols("%", "$", "€")
+
+	testCases := []struct {
+		s     string
+		value string
+		match bool
+	}{
+		{"swap.rate > 10", "12.5%", true},
+		{"swap.rate < 12.5", "12.5%", false},
+		{"tx.fee = 1.5", "$1.50", true},
+		{"tx.fee < 2", "€1.50", true},
+		{"tx.height = 100", "$100%", true},
+		// plain numbers are unaffected
+		{"swap.rate > 10", "12.5", true},
+		{"tx.height = 100", "100", true},
+		// string conditions are unaffected
+		{"tx.fee = '1.50'", "$1.50", false},
+	}
+
+	for _, tc := range testCases {
+		events := expandEvents(map[string][]string{strings.Fields(tc.s)[0]: {tc.value}})
+
+		q, err := query.New(tc.s, symbols)
+		require.NoError(t, err)
+		match, err := q.Matches(events)
+		require.NoError(t, err, "query %q, value %q", tc.s, tc.value)
+		require.Equal(t, tc.match, match, "query %q, value %q", tc.s, tc.value)
+	}
+}
+
+func TestEnum(t *testing.T) {
+	status := query.Enum("account.status", map[string]int{"ACTIVE": 1, "FROZEN": 2})
+
+	testCases := []struct {
+		s       string
+		value   string
+		matches bool
+	}{
+		{"account.status = 'ACTIVE'", "ACTIVE", true},
+		{"account.status = 'ACTIVE'", "1", true},
+		{"account.status = 1", "ACTIVE", true},
+		{"account.status = 1", "1", true},
+		{"account.status = 'ACTIVE'", "FROZEN", false},
+		{"account.status = 'ACTIVE'", "2", false},
+		{"account.status = 2", "ACTIVE", false},
+		// values outside the enumeration and other operators are unaffected
+		{"account.status = 'CLOSED'", "CLOSED", true},
+		{"account.status CONTAINS 'ACT'", "ACTIVE", true},
+		{"account.status CONTAINS 'ACT'", "1", false},
+		{"account.status > 1", "2", true},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, status)
+		require.NoError(t, err)
+
+		events := expandEvents(map[string][]string{"account.status": {tc.value}})
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q against %q", tc.s, tc.value)
+	}
+
+	// without the enumeration, names and codes are distinct
+	q, err := query.New("account.status = 'ACTIVE'")
+	require.NoError(t, err)
+	match, err := q.Matches(expandEvents(map[string][]string{"account.status": {"1"}}))
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestAllowComments(t *testing.T) {
+	events := expandEvents(map[string][]string{
+		"tm.event":  {"Tx"},
+		"tx.height": {"1001"},
+		"tx.memo":   {"#1 // first"},
+	})
+
+	testCases := []struct {
+		s    string
+		want string
+	}{
+		{"tm.event = 'Tx' # transactions", "tm.event = 'Tx'"},
+		{"tm.event = 'Tx' // transactions", "tm.event = 'Tx'"},
+		{`# all transactions
+tm.event = 'Tx'`, "tm.event = 'Tx'"},
+		{`tm.event = 'Tx'         # transactions
+	AND tx.height > 1000    // after the upgrade
+	# AND tx.height < 2000
+	AND tx.memo = '#1 // first'  # not a comment within quotes
+`, "tm.event = 'Tx' AND tx.height > 1000 AND tx.memo = '#1 // first'"},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.New(tc.s, query.AllowComments())
+		require.NoError(t, err, "query %q", tc.s)
+		require.Equal(t, tc.want, q.String())
+
+		plain := query.MustParse(tc.want)
+		conds, err := q.Conditions()
+		require.NoError(t, err)
+		want, err := plain.Conditions()
+		require.NoError(t, err)
+		require.Equal(t, want, conds)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.True(t, match, "query %q", tc.s)
+	}
+
+	// Without the option, comments are not recognized.
+	_, err := query.New("tm.event = 'Tx' # transactions")
+	require.Error(t, err)
+
+	// Placeholders in comments are not bound.
+	q, err := query.NewWithArgs("tx.height > $1 # not $2", 1000, query.AllowComments())
+	require.NoError(t, err)
+	require.Equal(t, "tx.height > 1000", q.String())
+}
+
+func TestCacheResults(t *testing.T) {
+	batches := [][]abci.Event{
+		expandEvents(map[string][]string{"tm.event": {"Tx"}, "tx.gas": {"8"}}),
+		expandEvents(map[string][]string{"tm.event": {"Tx"}, "tx.gas": {"3"}}),
+		expandEvents(map[string][]string{"tm.event": {"NewBlock"}, "tx.gas": {"8"}}),
+		// the same attributes split differently between events
+		{{Type: "tm", Attributes: []abci.EventAttribute{{Key: "event", Value: "Tx"}, {Key: "gas", Value: "8"}}}},
+		{{Type: "tm", Attributes: []abci.EventAttribute{{Key: "event", Value: "Tx"}}},
+			{Type: "tm", Attributes: []abci.EventAttribute{{Key: "gas", Value: "8"}}}},
+		{{Type: "tm", Attributes: []abci.EventAttribute{{Key: "event", Value: "Txtm"}}},
+			{Type: "gas", Attributes: []abci.EventAttribute{{Key: "8", Value: ""}}}},
+	}
+	const s = "tm.event = 'Tx' AND tx.gas > 5"
+
+	plain := query.MustParse(s)
+	for _, size := range []int{0, 2, 100} {
+		cached, err := query.New(s, query.CacheResults(size))
+		require.NoError(t, err)
+
+		// Each pass after the first is served (at least partly) from the cache.
+		for pass := 0; pass < 3; pass++ {
+			for i, events := range batches {
+				want, err := plain.Matches(events)
+				require.NoError(t, err)
+				got, err := cached.Matches(events)
+				require.NoError(t, err)
+				require.Equal(t, want, got, "size %d, pass %d, batch %d", size, pass, i)
+			}
+		}
+	}
+
+	// Errors are reported every time.
+	q, err := query.New("tx.gas > 5", query.CacheResults(10))
+	require.NoError(t, err)
+	events := expandEvents(map[string][]string{"tx.gas": {"lots"}})
+	for i := 0; i < 2; i++ {
+		_, err := q.Matches(events)
+		require.Error(t, err)
+	}
+}
+
+func TestMatchTimePrecision(t *testing.T) {
+	events := expandEvents(map[string][]string{
+		"tx.time": {"2013-05-03T14:45:00.123456789Z"},
+	})
+
+	testCases := []struct {
+		s         string
+		precision bool
+		matches   bool
+	}{
+		{"tx.time = TIME 2013-05-03T14:45:00Z", false, false},
+		{"tx.time = TIME 2013-05-03T14:45:00Z", true, true},
+		{"tx.time = TIME 2013-05-03T14:45:01Z", true, false},
+		{"tx.time = TIME 2013-05-03T16:45:00+02:00", true, true},
+		// other operators are unaffected
+		{"tx.time <= TIME 2013-05-03T14:45:00Z", true, false},
+		{"tx.time > TIME 2013-05-03T14:45:00Z", true, true},
+	}
+
+	for _, tc := range testCases {
+		var opts []query.Option
+		if tc.precision {
+			opts = append(opts, query.MatchTimePrecision())
+		}
+		q, err := query.New(tc.s, opts...)
+		require.NoError(t, err)
+
+		match, err := q.Matches(events)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "query %q (precision %v)", tc.s, tc.precision)
+	}
+
+	t.Run("Bound", func(t *testing.T) {
+		base := time.Date(2013, 5, 3, 14, 45, 0, 0, time.UTC)
+		testCases := []struct {
+			arg     time.Time
+			matches bool
+		}{
+			{base.Add(100 * time.Millisecond), true},
+			{base.Add(120 * time.Millisecond), true},
+			{base.Add(124 * time.Millisecond), false},
+			{base.Add(123456 * time.Microsecond), true},
+			{base.Add(123456789 * time.Nanosecond), true},
+			{base.Add(123456788 * time.Nanosecond), false},
+		}
+
+		for _, tc := range testCases {
+			q, err := query.NewWithArgs("tx.time = $1", tc.arg, query.MatchTimePrecision())
+			require.NoError(t, err)
+
+			match, err := q.Matches(events)
+			require.NoError(t, err)
+			require.Equal(t, tc.matches, match, "argument %v", tc.arg)
+		}
+	})
+}
+
+func TestNewWithArgs(t *testing.T) {
+	txTime, err := time.Parse(time.RFC3339, "2013-05-03T14:45:00Z")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		s          string
+		args       []interface{}
+		conditions []query.Condition
+		str        string
+	}{
+		{
+			s:    "tx.sender = $1 AND tx.height > $2",
+			args: []interface{}{"alice", 100},
+			conditions: []query.Condition{
+				{CompositeKey: "tx.sender", Op: query.OpEqual, Operand: "alice"},
+				{CompositeKey: "tx.height", Op: query.OpGreater, Operand: int64(100)},
+			},
+			str: "tx.sender = 'alice' AND tx.height > 100",
+		},
+		{
+			s:    "tx.memo CONTAINS $1 AND tx.memo = $1",
+			args: []interface{}{"o'clock AND tx.height > 1"},
+			conditions: []query.Condition{
+				{CompositeKey: "tx.memo", Op: query.OpContains, Operand: "o'clock AND tx.height > 1"},
+				{CompositeKey: "tx.memo", Op: query.OpEqual, Operand: "o'clock AND tx.height > 1"},
+			},
+		},
+		{
+			s:    "tx.fee <= $2 AND tx.time >= $1 AND tx.note = '$1'",
+			args: []interface{}{txTime, 2.5},
+			conditions: []query.Condition{
+				{CompositeKey: "tx.fee", Op: query.OpLessEqual, Operand: 2.5},
+				{CompositeKey: "tx.time", Op: query.OpGreaterEqual, Operand: txTime},
+				{CompositeKey: "tx.note", Op: query.OpEqual, Operand: "$1"},
+			},
+			str: "tx.fee <= 2.5 AND tx.time >= TIME 2013-05-03T14:45:00Z AND tx.note = '$1'",
+		},
+	}
+
+	for _, tc := range testCases {
+		q, err := query.NewWithArgs(tc.s, tc.args...)
+		require.NoError(t, err, "query %q", tc.s)
+
+		c, err := q.Conditions()
+		require.NoError(t, err)
+		require.Equal(t, tc.conditions, c)
+		if tc.str != "" {
+			require.Equal(t, tc.str, q.String())
+		}
+	}
+
+	t.Run("Matches", func(t *testing.T) {
+		q, err := query.NewWithArgs("tx.sender = $1 AND tx.height > $2", "o'brien", int64(5))
+		require.NoError(t, err)
+
+		match, err := q.Matches(expandEvents(map[string][]string{
+			"tx.sender": {"o'brien"},
+			"tx.height": {"6"},
+		}))
+		require.NoError(t, err)
+		require.True(t, match)
+
+		match, err = q.Matches(expandEvents(map[string][]string{
+			"tx.sender": {"o'brien"},
+			"tx.height": {"5"},
+		}))
+		require.NoError(t, err)
+		require.False(t, match)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, tc := range []struct {
+			s    string
+			args []interface{}
+		}{
+			{"tx.sender = $1", nil},                           // missing argument
+			{"tx.sender = $2", []interface{}{"a", "b"}},       // unused argument
+			{"tx.sender = $0", []interface{}{"a"}},            // out of range
+			{"tx.sender = $", []interface{}{"a"}},             // no index
+			{"tx.height > $1", []interface{}{"a"}},            // string in numeric comparison
+			{"tx.memo CONTAINS $1", []interface{}{5}},         // number in CONTAINS
+			{"tx.sender = $1", []interface{}{[]byte("a")}},    // unsupported type
+			{"tx.sender = $1 AND $1", []interface{}{"alice"}}, // misplaced operand
+		} {
+			_, err := query.NewWithArgs(tc.s, tc.args...)
+			require.Error(t, err, "query %q", tc.s)
+		}
+	})
+}
+
+func makeAllowlist(n int) map[string]struct{} {
+	set := make(map[string]struct{}, n)
+	for i := 0; i < n; i++ {
+		set[fmt.Sprintf("cosmos1addr%05d", i)] = struct{}{}
+	}
+	return set
+}
+
+func TestNewWithArgsSet(t *testing.T) {
+	allowlist := makeAllowlist(10000)
+
+	q, err := query.NewWithArgs("tm.event = 'Tx' AND tx.sender = $1", allowlist)
+	require.NoError(t, err)
+	require.Contains(t, q.String(), "@set(10000:")
+
+	testCases := []struct {
+		senders []string
+		matches bool
+	}{
+		{[]string{"cosmos1addr00000"}, true},
+		{[]string{"cosmos1addr09999"}, true},
+		{[]string{"cosmos1addr10000"}, false},
+		{[]string{"cosmos1addr"}, false},
+		{[]string{"mallory", "cosmos1addr04567"}, true},
+		{[]string{"mallory", "eve"}, false},
+	}
+	for _, tc := range testCases {
+		match, err := q.Matches(expandEvents(map[string][]string{
+			"tm.event":  {"Tx"},
+			"tx.sender": tc.senders,
+		}))
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, match, "senders %v", tc.senders)
+	}
+
+	// Queries bound to different sets must be distinguishable by String.
+	other, err := query.NewWithArgs("tm.event = 'Tx' AND tx.sender = $1", makeAllowlist(9999))
+	require.NoError(t, err)
+	require.NotEqual(t, q.String(), other.String())
+
+	_, err = query.NewWithArgs("tx.sender CONTAINS $1", allowlist)
+	require.Error(t, err)
+}
+
+func TestConditionsCopy(t *testing.T) {
+	q, err := query.NewWithArgs("tx.height > $1 AND tx.sender = $2", 5, map[string]struct{}{"alice": {}})
+	require.NoError(t, err)
+
+	conditions, err := q.Conditions()
+	require.NoError(t, err)
+	require.Equal(t, []query.Condition{
+		{CompositeKey: "tx.height", Op: query.OpGreater, Operand: int64(5)},
+		{CompositeKey: "tx.sender", Op: query.OpEqual, Operand: map[string]struct{}{"alice": {}}},
+	}, conditions)
+
+	// Modifying the conditions does not affect the query.
+	conditions[0].CompositeKey = "tx.gas"
+	set := conditions[1].Operand.(map[string]struct{})
+	delete(set, "alice")
+	set["mallory"] = struct{}{}
+
+	for sender, want := range map[string]bool{"alice": true, "mallory": false} {
+		match, err := q.Matches(expandEvents(map[string][]string{
+			"tx.height": {"7"},
+			"tx.sender": {sender},
+		}))
+		require.NoError(t, err)
+		require.Equal(t, want, match, "sender %q", sender)
+	}
+}
+
+func BenchmarkMatchesSet(b *testing.B) {
+	allowlist := makeAllowlist(10000)
+	q, err := query.NewWithArgs("tx.sender = $1", allowlist)
+	require.NoError(b, err)
+
+	events := expandEvents(map[string][]string{"tx.sender": {"cosmos1addr09999"}})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, err := q.Matches(events); err != nil || !ok {
+			b.Fatalf("Matches: got %v, %v", ok, err)
+		}
+	}
+}
+
+func BenchmarkMatchesCached(b *testing.B) {
+	events := expandEvents(map[string][]string{
+		"tm.event":        {"Tx"},
+		"tx.height":       {"1000"},
+		"tx.hash":         {"4AE393495334"},
+		"transfer.sender": {"addr1", "addr2", "addr3"},
+		"transfer.amount": {"100stake", "2000stake", "30000stake"},
+	})
+	const s = "tm.event = 'Tx' AND tx.height > 5 AND transfer.sender = 'addr3' AND transfer.amount CONTAINS 'stake'"
+
+	b.Run("Uncached", func(b *testing.B) {
+		q := query.MustParse(s)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := q.Matches(events); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Cached", func(b *testing.B) {
+		q, err := query.New(s, query.CacheResults(100))
+		require.NoError(b, err)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := q.Matches(events); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMatchesRepeatedValues(b *testing.B) {
+	// A batch in which many events share a height and time, as events of the
+	// transactions of a block do.
+	events := make([]abci.Event, 1000)
+	for i := range events {
+		events[i] = abci.Event{Type: "tx", Attributes: []abci.EventAttribute{
+			{Key: "height", Value: "100"},
+			{Key: "time", Value: "2013-05-03T14:45:00Z"},
+		}}
+	}
+
+	for _, s := range []string{
+		"tx.height > 200",
+		"tx.height > 200.5",
+		"tx.time > TIME 2013-05-04T00:00:00Z",
+	} {
+		q := query.MustParse(s)
+		b.Run(s, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if match, err := q.Matches(events); err != nil || match {
+					b.Fatalf("Matches = %v, %v", match, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchesSingleType(b *testing.B) {
+	// A batch dominated by events of types the query does not refer to.
+	events := make([]abci.Event, 0, 1000)
+	for i := 0; i < 999; i++ {
+		events = append(events, abci.Event{Type: "transfer", Attributes: []abci.EventAttribute{
+			{Key: "sender", Value: "alice"},
+			{Key: "recipient", Value: "bob"},
+			{Key: "amount", Value: fmt.Sprintf("%dstake", i)},
+		}})
+	}
+	events = append(events, abci.Event{Type: "tx", Attributes: []abci.EventAttribute{
+		{Key: "height", Value: "100"},
+		{Key: "gas", Value: "20"},
+	}})
+
+	for name, s := range map[string]string{
+		"Specialized": "tx.height > 5 AND tx.gas < 100",
+		// A type prefix makes the query refer to no single type, so it is
+		// matched generically. No other type of the batch starts with "tx".
+		"Generic": "tx*.height > 5 AND tx*.gas < 100",
+	} {
+		q := query.MustParse(s)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if match, err := q.Matches(events); err != nil || !match {
+					b.Fatalf("Matches = %v, %v", match, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"github.com/tendermint/tendermint/abci/types"
+)
+
+// A CompiledSet matches events against several queries at once. Conditions
+// that appear in more than one of the queries are evaluated at most once per
+// call to Match, and the events are flattened and their values parsed only
+// once for all the queries.
+type CompiledSet struct {
+	queries []*Query
+	conds   []Condition // distinct conditions of all the queries
+	uses    [][]int     // per query, indices of its conditions in conds
+}
+
+// CompileSet parses queries with the given options and compiles them into a
+// set, or returns an error if any of them fails to parse.
+func CompileSet(queries []string, options ...Option) (*CompiledSet, error) {
+	set := &CompiledSet{
+		queries: make([]*Query, len(queries)),
+		uses:    make([][]int, len(queries)),
+	}
+	index := make(map[string]int)
+	for i, s := range queries {
+		q, err := New(s, options...)
+		if err != nil {
+			return nil, err
+		}
+		conditions, err := q.conditions()
+		if err != nil {
+			return nil, err
+		}
+
+		set.queries[i] = q
+		set.uses[i] = make([]int, len(conditions))
+		for j, cond := range conditions {
+			key := cond.String()
+			k, ok := index[key]
+			if !ok {
+				k = len(set.conds)
+				index[key] = k
+				set.conds = append(set.conds, cond)
+			}
+			set.uses[i][j] = k
+		}
+	}
+	return set, nil
+}
+
+// Len returns the number of queries in the set.
+func (s *CompiledSet) Len() int { return len(s.queries) }
+
+// Conditions returns the number of distinct conditions in the set.
+func (s *CompiledSet) Conditions() int { return len(s.conds) }
+
+// Match reports, for each query of the set in the order given to CompileSet,
+// whether it matches events. A query whose evaluation fails, e.g. because an
+// attribute value cannot be converted to the type of an operand, is reported
+// as not matching. Results are not cached (see CacheResults).
+func (s *CompiledSet) Match(rawEvents []types.Event) []bool {
+	matched := make([]bool, len(s.queries))
+	if len(rawEvents) == 0 || len(s.queries) == 0 {
+		return matched
+	}
+
+	// The options, and so the excluded types, are the same for all queries.
+	events := flattenEvents(rawEvents, s.queries[0].exclude)
+	memo := make(valueMemo)
+
+	const (
+		unknown uint8 = iota
+		yes
+		no
+	)
+	results := make([]uint8, len(s.conds))
+	for i, q := range s.queries {
+		matched[i] = true
+		for _, k := range s.uses[i] {
+			if results[k] == unknown {
+				results[k] = no
+				if match, err := q.matchCondition(s.conds[k], events, memo); err == nil && match {
+					results[k] = yes
+				}
+			}
+			if results[k] == no {
+				matched[i] = false
+				break
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,114 @@
+package query_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/pubsub/query"
+)
+
+func TestCompileSet(t *testing.T) {
+	queries := []string{
+		"tm.event = 'Tx' AND tx.height > 5",
+		"tm.event = 'Tx' AND tx.height > 5 AND transfer.sender = 'alice'",
+		"tm.event = 'Tx' AND transfer.sender = 'bob'",
+		"tm.event = 'NewBlock'",
+		"tx.height > 5 AND tx.height < 100",
+		"tx.memo > 5",
+	}
+	set, err := query.CompileSet(queries)
+	require.NoError(t, err)
+	require.Equal(t, len(queries), set.Len())
+	require.Equal(t, 7, set.Conditions())
+
+	testCases := []struct {
+		events map[string][]string
+		want   []bool
+	}{
+		{
+			map[string][]string{
+				"tm.event": {"Tx"}, "tx.height": {"10"}, "tx.memo": {"hello"}, "transfer.sender": {"alice"},
+			},
+			[]bool{true, true, false, false, true, false},
+		},
+		{
+			map[string][]string{"tm.event": {"Tx"}, "tx.height": {"3"}, "transfer.sender": {"bob"}},
+			[]bool{false, false, true, false, false, false},
+		},
+		{
+			map[string][]string{"tm.event": {"NewBlock"}, "tx.height": {"500"}},
+			[]bool{false, false, false, true, false, false},
+		},
+		{nil, []bool{false, false, false, false, false, false}},
+	}
+	for i, tc := range testCases {
+		events := expandEvents(tc.events)
+		require.Equal(t, tc.want, set.Match(events), "case %d", i)
+
+		// The set agrees with the queries evaluated independently.
+		for j, s := range queries {
+			match, err := query.MustParse(s).Matches(events)
+			require.Equal(t, tc.want[j], err == nil && match, "case %d, query %q", i, s)
+		}
+	}
+
+	// Time operands are deduplicated by instant, whatever their zone.
+	set, err = query.CompileSet([]string{
+		"tx.time < TIME 2013-05-03T14:45:00Z",
+		"tx.time < TIME 2013-05-03T16:45:00+02:00",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, set.Conditions())
+
+	_, err = query.CompileSet([]string{"tm.event = 'Tx'", "tm.event = "})
+	require.Error(t, err)
+}
+
+func TestCompileSetOptions(t *testing.T) {
+	set, err := query.CompileSet([]string{
+		"tm.event = 'Tx'",
+		"transfer.sender = 'alice'",
+	}, query.ExcludeTypes([]string{"transfer"}))
+	require.NoError(t, err)
+
+	events := expandEvents(map[string][]string{"tm.event": {"Tx"}, "transfer.sender": {"alice"}})
+	require.Equal(t, []bool{true, false}, set.Match(events))
+}
+
+func BenchmarkCompileSet(b *testing.B) {
+	var queries []string
+	for i := 0; i < 50; i++ {
+		queries = append(queries, fmt.Sprintf("tm.event = 'Tx' AND tx.height > 5 AND transfer.sender = 'user%d'", i))
+	}
+	events := expandEvents(map[string][]string{
+		"tm.event":          {"Tx"},
+		"tx.height":         {"10"},
+		"transfer.sender":   {"user7"},
+		"transfer.receiver": {"user8"},
+		"transfer.amount":   {"100stake"},
+	})
+
+	b.Run("Independent", func(b *testing.B) {
+		qs := make([]*query.Query, len(queries))
+		for i, s := range queries {
+			qs[i] = query.MustParse(s)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, q := range qs {
+				if _, err := q.Matches(events); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Set", func(b *testing.B) {
+		set, err := query.CompileSet(queries)
+		require.NoError(b, err)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			set.Match(events)
+		}
+	})
+}
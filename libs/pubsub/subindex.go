@@ -10,10 +10,12 @@ type item struct {
 
 // A subInfo value records a single subscription.
 type subInfo struct {
-	clientID string        // chosen by the client
-	query    Query         // chosen by the client
-	subID    string        // assigned at registration
-	sub      *Subscription // receives published events
+	clientID string                   // chosen by the client
+	query    Query                    // chosen by the client
+	filter   func([]types.Event) bool // chosen by the client (optional)
+	labels   string                   // chosen by the client, for metrics
+	subID    string                   // assigned at registration
+	sub      *Subscription            // receives published events
 }
 
 // A subInfoSet is an unordered set of subscription info records.
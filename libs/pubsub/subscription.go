@@ -3,6 +3,7 @@ package pubsub
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	"github.com/tendermint/tendermint/abci/types"
@@ -16,6 +17,10 @@ var (
 	// ErrTerminated is returned by Next when the subscription was terminated by
 	// the publisher.
 	ErrTerminated = errors.New("subscription terminated by publisher")
+
+	// errByteLimit is reported by publish when a message does not fit in the
+	// byte budget of the subscription.
+	errByteLimit = errors.New("subscription byte limit exceeded")
 )
 
 // A Subscription represents a client subscription for a particular query.
@@ -23,10 +28,14 @@ type Subscription struct {
 	id      string
 	queue   *queue.Queue // open until the subscription ends
 	stopErr error        // after queue is closed, the reason why
+
+	maxBytes int64 // limit on queued message sizes (0 means no limit)
+	bytes    int64 // total size of queued messages (atomic)
 }
 
-// newSubscription returns a new subscription with the given queue capacity.
-func newSubscription(quota, limit int) (*Subscription, error) {
+// newSubscription returns a new subscription with the given queue capacity
+// and byte limit.
+func newSubscription(quota, limit, maxBytes int) (*Subscription, error) {
 	queue, err := queue.New(queue.Options{
 		SoftQuota: quota,
 		HardLimit: limit,
@@ -35,8 +44,9 @@ func newSubscription(quota, limit int) (*Subscription, error) {
 		return nil, err
 	}
 	return &Subscription{
-		id:    uuid.NewString(),
-		queue: queue,
+		id:       uuid.NewString(),
+		queue:    queue,
+		maxBytes: int64(maxBytes),
 	}, nil
 }
 
@@ -51,15 +61,34 @@ func (s *Subscription) Next(ctx context.Context) (Message, error) {
 	} else if err != nil {
 		return Message{}, err
 	}
-	return next.(Message), nil
+	msg := next.(Message)
+	if s.maxBytes > 0 {
+		atomic.AddInt64(&s.bytes, -msg.size)
+	}
+	return msg, nil
 }
 
 // ID returns the unique subscription identifier for s.
 func (s *Subscription) ID() string { return s.id }
 
-// publish transmits msg to the subscriber. It reports a queue error if the
-// queue cannot accept any further messages.
-func (s *Subscription) publish(msg Message) error { return s.queue.Add(msg) }
+// publish transmits msg to the subscriber. It reports an error if the queue
+// cannot accept any further messages, or if msg would exceed the byte limit.
+// If s has a byte limit, msg.size must be populated.
+func (s *Subscription) publish(msg Message) error {
+	if s.maxBytes <= 0 {
+		return s.queue.Add(msg)
+	}
+
+	if atomic.AddInt64(&s.bytes, msg.size) > s.maxBytes {
+		atomic.AddInt64(&s.bytes, -msg.size)
+		return errByteLimit
+	}
+	if err := s.queue.Add(msg); err != nil {
+		atomic.AddInt64(&s.bytes, -msg.size)
+		return err
+	}
+	return nil
+}
 
 // stop terminates the subscription with the given error reason.
 func (s *Subscription) stop(err error) {
@@ -75,6 +104,7 @@ type Message struct {
 	subID  string
 	data   interface{}
 	events []types.Event
+	size   int64 // see Size; populated for subscriptions with a byte limit
 }
 
 // SubscriptionID returns the unique identifier for the subscription
@@ -86,3 +116,26 @@ func (msg Message) Data() interface{} { return msg.data }
 
 // Events returns events, which matched the client's query.
 func (msg Message) Events() []types.Event { return msg.events }
+
+// Size returns the approximate size of msg in bytes, for enforcing the byte
+// limits of subscriptions. It counts the lengths of the types, keys and values
+// of the events, and the size of the data if it has a Size() int method (as
+// protobuf messages do) or if it is a string or a []byte.
+func (msg Message) Size() int64 {
+	var size int
+	for _, event := range msg.events {
+		size += len(event.Type)
+		for _, attr := range event.Attributes {
+			size += len(attr.Key) + len(attr.Value)
+		}
+	}
+	switch data := msg.data.(type) {
+	case interface{ Size() int }:
+		size += data.Size()
+	case string:
+		size += len(data)
+	case []byte:
+		size += len(data)
+	}
+	return int64(size)
+}
@@ -177,7 +177,7 @@ func makeNode(
 	// we might need to index the txs of the replayed block as this might not have happened
 	// when the node stopped last time (i.e. the node stopped after it saved the block
 	// but before it indexed the txs, or, endblocker panicked)
-	eventBus, err := createAndStartEventBus(ctx, logger)
+	eventBus, err := createAndStartEventBus(ctx, cfg, logger, nodeMetrics.eventbus)
 	if err != nil {
 		return nil, combineCloseError(err, makeCloser(closers))
 	}
@@ -887,6 +887,7 @@ func defaultGenesisDocProviderFunc(cfg *config.Config) genesisDocProvider {
 
 type nodeMetrics struct {
 	consensus *consensus.Metrics
+	eventbus  *tmpubsub.Metrics
 	indexer   *indexer.Metrics
 	mempool   *mempool.Metrics
 	p2p       *p2p.Metrics
@@ -905,6 +906,7 @@ func defaultMetricsProvider(cfg *config.InstrumentationConfig) metricsProvider {
 		if cfg.Prometheus {
 			return &nodeMetrics{
 				consensus: consensus.PrometheusMetrics(cfg.Namespace, "chain_id", chainID),
+				eventbus:  tmpubsub.PrometheusMetrics(cfg.Namespace, "chain_id", chainID),
 				indexer:   indexer.PrometheusMetrics(cfg.Namespace, "chain_id", chainID),
 				mempool:   mempool.PrometheusMetrics(cfg.Namespace, "chain_id", chainID),
 				p2p:       p2p.PrometheusMetrics(cfg.Namespace, "chain_id", chainID),
@@ -915,6 +917,7 @@ func defaultMetricsProvider(cfg *config.InstrumentationConfig) metricsProvider {
 		}
 		return &nodeMetrics{
 			consensus: consensus.NopMetrics(),
+			eventbus:  tmpubsub.NopMetrics(),
 			indexer:   indexer.NopMetrics(),
 			mempool:   mempool.NopMetrics(),
 			p2p:       p2p.NopMetrics(),
@@ -572,7 +572,7 @@ func TestNodeSetEventSink(t *testing.T) {
 
 	logger := log.TestingLogger()
 	setupTest := func(t *testing.T, conf *config.Config) []indexer.EventSink {
-		eventBus, err := createAndStartEventBus(ctx, logger)
+		eventBus, err := createAndStartEventBus(ctx, cfg, logger, pubsub.NopMetrics())
 		require.NoError(t, err)
 		t.Cleanup(eventBus.Wait)
 		genDoc, err := types.GenesisDocFromFile(cfg.GenesisFile())
@@ -28,6 +28,7 @@ import (
 	"github.com/tendermint/tendermint/internal/statesync"
 	"github.com/tendermint/tendermint/internal/store"
 	"github.com/tendermint/tendermint/libs/log"
+	tmpubsub "github.com/tendermint/tendermint/libs/pubsub"
 	"github.com/tendermint/tendermint/libs/service"
 	tmstrings "github.com/tendermint/tendermint/libs/strings"
 	"github.com/tendermint/tendermint/types"
@@ -108,8 +109,20 @@ func createAndStartProxyAppConns(
 	return proxyApp, nil
 }
 
-func createAndStartEventBus(ctx context.Context, logger log.Logger) (*eventbus.EventBus, error) {
-	eventBus := eventbus.NewDefault(logger.With("module", "events"))
+func createAndStartEventBus(
+	ctx context.Context,
+	cfg *config.Config,
+	logger log.Logger,
+	metrics *tmpubsub.Metrics,
+) (*eventbus.EventBus, error) {
+	options := []tmpubsub.Option{
+		tmpubsub.WithMetrics(metrics),
+		tmpubsub.MatchTimeout(cfg.RPC.SubscriptionMatchTimeout),
+	}
+	if cfg.Instrumentation.QueryLabels {
+		options = append(options, tmpubsub.LabelQueries())
+	}
+	eventBus := eventbus.NewDefault(logger.With("module", "events"), options...)
 	if err := eventBus.Start(ctx); err != nil {
 		return nil, err
 	}
@@ -3,6 +3,7 @@ package types
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	abci "github.com/tendermint/tendermint/abci/types"
 	tmjson "github.com/tendermint/tendermint/libs/json"
@@ -30,12 +31,16 @@ const (
 	// The BlockSyncStatus event will be emitted when the node switching
 	// state sync mechanism between the consensus reactor and the blocksync reactor.
 	EventBlockSyncStatusValue = "BlockSyncStatus"
+	EventHeightSummaryValue   = "HeightSummary"
 	EventLockValue            = "Lock"
 	EventNewRoundValue        = "NewRound"
 	EventNewRoundStepValue    = "NewRoundStep"
 	EventPolkaValue           = "Polka"
 	EventRelockValue          = "Relock"
+	EventRoundFailureValue    = "RoundFailure"
+	EventRoundStepValue       = "RoundStep"
 	EventStateSyncStatusValue = "StateSyncStatus"
+	EventTimeoutDeadlineValue = "TimeoutDeadline"
 	EventTimeoutProposeValue  = "TimeoutPropose"
 	EventTimeoutWaitValue     = "TimeoutWait"
 	EventUnlockValue          = "Unlock"
@@ -99,6 +104,10 @@ func init() {
 	tmjson.RegisterType(EventDataNewEvidence{}, "tendermint/event/NewEvidence")
 	tmjson.RegisterType(EventDataTx{}, "tendermint/event/Tx")
 	tmjson.RegisterType(EventDataRoundState{}, "tendermint/event/RoundState")
+	tmjson.RegisterType(EventDataRoundStep{}, "tendermint/event/RoundStep")
+	tmjson.RegisterType(EventDataRoundFailure{}, "tendermint/event/RoundFailure")
+	tmjson.RegisterType(EventDataTimeoutDeadline{}, "tendermint/event/TimeoutDeadline")
+	tmjson.RegisterType(EventDataHeightSummary{}, "tendermint/event/HeightSummary")
 	tmjson.RegisterType(EventDataNewRound{}, "tendermint/event/NewRound")
 	tmjson.RegisterType(EventDataCompleteProposal{}, "tendermint/event/CompleteProposal")
 	tmjson.RegisterType(EventDataVote{}, "tendermint/event/Vote")
@@ -145,6 +154,69 @@ type EventDataRoundState struct {
 	Step   string `json:"step"`
 }
 
+// EventDataRoundStep is published on every step transition of the consensus
+// state machine, with the local time at which the step was entered.
+type EventDataRoundStep struct {
+	Height int64     `json:"height"`
+	Round  int32     `json:"round"`
+	Step   string    `json:"step"`
+	Time   time.Time `json:"time"`
+}
+
+// RoundFailureReason is the reason a round ended without a commit.
+type RoundFailureReason string
+
+const (
+	// The proposal did not arrive before the propose timeout.
+	RoundFailureProposeTimeout RoundFailureReason = "propose-timeout"
+	// +2/3 of the voting power prevoted nil, e.g. for an invalid proposal.
+	RoundFailureNilPrevoteQuorum RoundFailureReason = "nil-prevote-quorum"
+	// Prevotes from +2/3 of the voting power did not agree on a block or
+	// nil before the prevote timeout.
+	RoundFailurePrevoteTimeout RoundFailureReason = "prevote-timeout"
+	// Precommits from +2/3 of the voting power did not agree on a block
+	// before the precommit timeout.
+	RoundFailurePrecommitTimeout RoundFailureReason = "precommit-timeout"
+	// The round was skipped because +2/3 of the voting power voted in a
+	// later round.
+	RoundFailureSkipped RoundFailureReason = "skipped"
+)
+
+// EventDataRoundFailure is published when the consensus state machine moves
+// to a new round of a height, for the round it leaves. Reason is the first
+// failure observed in that round.
+type EventDataRoundFailure struct {
+	Height int64              `json:"height"`
+	Round  int32              `json:"round"`
+	Reason RoundFailureReason `json:"reason"`
+}
+
+// EventDataTimeoutDeadline is published when the consensus state machine
+// schedules a timeout, with the local time at which the timeout is due. Step
+// is the step that the timeout ends, e.g. RoundStepPropose for the propose
+// timeout.
+type EventDataTimeoutDeadline struct {
+	Height   int64     `json:"height"`
+	Round    int32     `json:"round"`
+	Step     string    `json:"step"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// EventDataHeightSummary is published when the consensus state machine commits
+// a block. Prevotes and Precommits count the votes received in the round in
+// which the block was committed, and TimedOut reports whether a propose,
+// prevote or precommit timeout expired at any round of the height.
+type EventDataHeightSummary struct {
+	Height      int64     `json:"height"`
+	BlockTime   time.Time `json:"block_time"`
+	Proposer    Address   `json:"proposer"`
+	CommitRound int32     `json:"commit_round"`
+	Prevotes    int       `json:"prevotes"`
+	Precommits  int       `json:"precommits"`
+	TimedOut    bool      `json:"timed_out"`
+	CommitTime  time.Time `json:"commit_time"`
+}
+
 type ValidatorInfo struct {
 	Address Address `json:"address"`
 	Index   int32   `json:"index"`
@@ -212,6 +284,7 @@ const (
 
 var (
 	EventQueryCompleteProposal    = QueryForEvent(EventCompleteProposalValue)
+	EventQueryHeightSummary       = QueryForEvent(EventHeightSummaryValue)
 	EventQueryLock                = QueryForEvent(EventLockValue)
 	EventQueryNewBlock            = QueryForEvent(EventNewBlockValue)
 	EventQueryNewBlockHeader      = QueryForEvent(EventNewBlockHeaderValue)
@@ -220,6 +293,9 @@ var (
 	EventQueryNewRoundStep        = QueryForEvent(EventNewRoundStepValue)
 	EventQueryPolka               = QueryForEvent(EventPolkaValue)
 	EventQueryRelock              = QueryForEvent(EventRelockValue)
+	EventQueryRoundFailure        = QueryForEvent(EventRoundFailureValue)
+	EventQueryRoundStep           = QueryForEvent(EventRoundStepValue)
+	EventQueryTimeoutDeadline     = QueryForEvent(EventTimeoutDeadlineValue)
 	EventQueryTimeoutPropose      = QueryForEvent(EventTimeoutProposeValue)
 	EventQueryTimeoutWait         = QueryForEvent(EventTimeoutWaitValue)
 	EventQueryTx                  = QueryForEvent(EventTxValue)